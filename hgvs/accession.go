@@ -0,0 +1,124 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package hgvs
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/zymatik-com/genobase/types"
+)
+
+// RefSeq accession versions of the nuclear chromosomes (NC_0000NN), by
+// assembly.
+var chromosomeVersions = []struct {
+	chromosome             types.Chromosome
+	ncbi36, grch37, grch38 int
+}{
+	{types.Chr1, 9, 10, 11},
+	{types.Chr2, 10, 11, 12},
+	{types.Chr3, 10, 11, 12},
+	{types.Chr4, 10, 11, 12},
+	{types.Chr5, 8, 9, 10},
+	{types.Chr6, 10, 11, 12},
+	{types.Chr7, 12, 13, 14},
+	{types.Chr8, 9, 10, 11},
+	{types.Chr9, 10, 11, 12},
+	{types.Chr10, 9, 10, 11},
+	{types.Chr11, 8, 9, 10},
+	{types.Chr12, 10, 11, 12},
+	{types.Chr13, 9, 10, 11},
+	{types.Chr14, 7, 8, 9},
+	{types.Chr15, 8, 9, 10},
+	{types.Chr16, 8, 9, 10},
+	{types.Chr17, 9, 10, 11},
+	{types.Chr18, 8, 9, 10},
+	{types.Chr19, 8, 9, 10},
+	{types.Chr20, 9, 10, 11},
+	{types.Chr21, 7, 8, 9},
+	{types.Chr22, 9, 10, 11},
+	{types.ChrX, 9, 10, 11},
+	{types.ChrY, 8, 9, 10},
+}
+
+// Mitochondrial genome accessions, the revised Cambridge Reference Sequence
+// is shared by the newer assemblies.
+const (
+	ncbi36MitochondrialAccession = "NC_001807.4"
+	rCRSMitochondrialAccession   = "NC_012920.1"
+)
+
+// T2T-CHM13v2.0 chromosomes are numbered sequentially from NC_060925.1.
+const t2tFirstAccession = 60925
+
+var (
+	accessions       = make(map[types.Reference]map[types.Chromosome]string)
+	accessionsLookup = make(map[string]accessionLocation)
+)
+
+type accessionLocation struct {
+	chromosome types.Chromosome
+	references []types.Reference
+}
+
+func init() {
+	add := func(reference types.Reference, chromosome types.Chromosome, accession string) {
+		if accessions[reference] == nil {
+			accessions[reference] = make(map[types.Chromosome]string)
+		}
+		accessions[reference][chromosome] = accession
+
+		location := accessionsLookup[accession]
+		location.chromosome = chromosome
+		location.references = append(location.references, reference)
+		accessionsLookup[accession] = location
+	}
+
+	for i, v := range chromosomeVersions {
+		number := i + 1
+
+		add(types.ReferenceNCBI36, v.chromosome, fmt.Sprintf("NC_%06d.%d", number, v.ncbi36))
+		add(types.ReferenceGRCh37, v.chromosome, fmt.Sprintf("NC_%06d.%d", number, v.grch37))
+		add(types.ReferenceGRCh38, v.chromosome, fmt.Sprintf("NC_%06d.%d", number, v.grch38))
+		add(types.ReferenceTelomereToTelomereV2, v.chromosome, fmt.Sprintf("NC_%06d.1", t2tFirstAccession+i))
+	}
+
+	add(types.ReferenceNCBI36, types.ChrMT, ncbi36MitochondrialAccession)
+	add(types.ReferenceGRCh37, types.ChrMT, rCRSMitochondrialAccession)
+	add(types.ReferenceGRCh38, types.ChrMT, rCRSMitochondrialAccession)
+	add(types.ReferenceTelomereToTelomereV2, types.ChrMT, rCRSMitochondrialAccession)
+}
+
+// Accession returns the RefSeq accession (eg. "NC_000017.11") of a chromosome
+// in a reference genome assembly.
+func Accession(reference types.Reference, chromosome types.Chromosome) (string, error) {
+	accession, ok := accessions[reference][chromosome]
+	if !ok {
+		return "", fmt.Errorf("no accession for chromosome %s in %s", chromosome, reference)
+	}
+
+	return accession, nil
+}
+
+// ResolveAccession returns the chromosome, and the reference genome assemblies,
+// of a RefSeq chromosome accession (eg. "NC_000017.10" is chromosome 17 in
+// GRCh37). Most accessions belong to a single assembly, but some (eg. the
+// mitochondrial genome, NC_012920.1) are shared by several.
+func ResolveAccession(accession string) (types.Chromosome, []types.Reference, error) {
+	location, ok := accessionsLookup[accession]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown accession %q", accession)
+	}
+
+	return location.chromosome, slices.Clone(location.references), nil
+}
@@ -0,0 +1,190 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+// Package hgvs parses and formats genomic (g.) HGVS variant descriptions
+// (eg. "NC_000017.10:g.41245466G>A").
+package hgvs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EditType is the type of change described by a variant.
+type EditType string
+
+// Supported edit types.
+const (
+	Substitution      EditType = ">"
+	Deletion          EditType = "del"
+	Duplication       EditType = "dup"
+	Insertion         EditType = "ins"
+	DeletionInsertion EditType = "delins"
+	Inversion         EditType = "inv"
+	Identity          EditType = "="
+)
+
+const (
+	genomicCoordinates   = ":g."
+	rangeSeparator       = "_"
+	supportedNucleotides = "ACGTN"
+)
+
+// Variant is a genomic HGVS variant description.
+type Variant struct {
+	Accession string   // Reference sequence accession (eg. "NC_000017.10").
+	Start     int64    // Start position (1-based, inclusive).
+	End       int64    // End position (1-based, inclusive), the same as Start for a single position.
+	Type      EditType // Type of change.
+	// Ref is the reference sequence, for substitutions, and optionally
+	// deletions, duplications, inversions and identities.
+	Ref string
+	// Alt is the alternate sequence for substitutions, or the inserted
+	// sequence for insertions and deletion-insertions.
+	Alt string
+}
+
+// Parse parses a genomic HGVS variant description (eg.
+// "NC_000017.10:g.41245466G>A", or "NC_000017.10:g.41245466_41245467insT").
+// Uncertain positions, and descriptions of multiple variants (alleles) are not
+// supported.
+func Parse(description string) (*Variant, error) {
+	accession, rest, ok := strings.Cut(strings.TrimSpace(description), genomicCoordinates)
+	if !ok || accession == "" {
+		return nil, fmt.Errorf("invalid genomic hgvs description %q", description)
+	}
+
+	v := &Variant{
+		Accession: accession,
+	}
+
+	var err error
+	v.Start, rest, err = parsePosition(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hgvs position in %q: %w", description, err)
+	}
+
+	v.End = v.Start
+	if strings.HasPrefix(rest, rangeSeparator) {
+		v.End, rest, err = parsePosition(rest[len(rangeSeparator):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hgvs position in %q: %w", description, err)
+		}
+
+		if v.End <= v.Start {
+			return nil, fmt.Errorf("invalid hgvs range in %q", description)
+		}
+	}
+
+	if err := v.parseEdit(rest); err != nil {
+		return nil, fmt.Errorf("invalid hgvs edit in %q: %w", description, err)
+	}
+
+	return v, nil
+}
+
+// String formats the variant as a genomic HGVS description.
+func (v *Variant) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(v.Accession)
+	sb.WriteString(genomicCoordinates)
+	sb.WriteString(strconv.FormatInt(v.Start, 10))
+	if v.End != v.Start {
+		sb.WriteString(rangeSeparator)
+		sb.WriteString(strconv.FormatInt(v.End, 10))
+	}
+
+	switch v.Type {
+	case Substitution:
+		sb.WriteString(v.Ref + string(Substitution) + v.Alt)
+	case Identity:
+		sb.WriteString(v.Ref + string(Identity))
+	case Insertion, DeletionInsertion:
+		sb.WriteString(string(v.Type) + v.Alt)
+	default:
+		sb.WriteString(string(v.Type) + v.Ref)
+	}
+
+	return sb.String()
+}
+
+func (v *Variant) parseEdit(edit string) error {
+	switch {
+	case strings.HasPrefix(edit, string(DeletionInsertion)):
+		v.Type, v.Alt = DeletionInsertion, edit[len(DeletionInsertion):]
+		if v.Alt == "" {
+			return fmt.Errorf("missing inserted sequence")
+		}
+	case strings.HasPrefix(edit, string(Deletion)):
+		v.Type, v.Ref = Deletion, edit[len(Deletion):]
+	case strings.HasPrefix(edit, string(Duplication)):
+		v.Type, v.Ref = Duplication, edit[len(Duplication):]
+	case strings.HasPrefix(edit, string(Inversion)):
+		v.Type, v.Ref = Inversion, edit[len(Inversion):]
+		if v.End == v.Start {
+			return fmt.Errorf("inversion of a single position")
+		}
+	case strings.HasPrefix(edit, string(Insertion)):
+		v.Type, v.Alt = Insertion, edit[len(Insertion):]
+		if v.Alt == "" {
+			return fmt.Errorf("missing inserted sequence")
+		}
+
+		// Insertions are described by the flanking positions.
+		if v.End != v.Start+1 {
+			return fmt.Errorf("insertion not between adjacent positions")
+		}
+	case strings.HasSuffix(edit, string(Identity)):
+		v.Type, v.Ref = Identity, strings.TrimSuffix(edit, string(Identity))
+	case strings.Contains(edit, string(Substitution)):
+		v.Type = Substitution
+		v.Ref, v.Alt, _ = strings.Cut(edit, string(Substitution))
+		if len(v.Ref) != 1 || len(v.Alt) != 1 || v.End != v.Start {
+			return fmt.Errorf("substitution of more than one nucleotide")
+		}
+	default:
+		return fmt.Errorf("unsupported edit %q", edit)
+	}
+
+	for _, sequence := range []string{v.Ref, v.Alt} {
+		if strings.Trim(sequence, supportedNucleotides) != "" {
+			return fmt.Errorf("invalid sequence %q", sequence)
+		}
+	}
+
+	if v.Ref != "" && v.Type != Substitution && int64(len(v.Ref)) != v.End-v.Start+1 {
+		return fmt.Errorf("reference sequence %q does not match the length of the range", v.Ref)
+	}
+
+	return nil
+}
+
+// parsePosition parses a leading position, returning the rest of the string.
+func parsePosition(s string) (int64, string, error) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+
+	position, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if position < 1 {
+		return 0, "", fmt.Errorf("position %d out of range", position)
+	}
+
+	return position, s[i:], nil
+}
@@ -0,0 +1,114 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package hgvs_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/hgvs"
+)
+
+func TestParse(t *testing.T) {
+	v, err := hgvs.Parse("NC_000017.10:g.41245466G>A")
+	require.NoError(t, err)
+
+	assert.Equal(t, &hgvs.Variant{
+		Accession: "NC_000017.10",
+		Start:     41245466,
+		End:       41245466,
+		Type:      hgvs.Substitution,
+		Ref:       "G",
+		Alt:       "A",
+	}, v)
+
+	v, err = hgvs.Parse("NC_000017.10:g.41245466_41245467insTTA")
+	require.NoError(t, err)
+
+	assert.Equal(t, &hgvs.Variant{
+		Accession: "NC_000017.10",
+		Start:     41245466,
+		End:       41245467,
+		Type:      hgvs.Insertion,
+		Alt:       "TTA",
+	}, v)
+
+	for _, description := range []string{
+		"NC_000017.10:g.41245466G>A",
+		"NC_000017.10:g.41245466G=",
+		"NC_000017.10:g.41245466del",
+		"NC_000017.10:g.41245466_41245468delGAT",
+		"NC_000017.10:g.41245466_41245468dup",
+		"NC_000017.10:g.41245466_41245467insTTA",
+		"NC_000017.10:g.41245466_41245468delinsCC",
+		"NC_000017.10:g.41245466_41245468inv",
+	} {
+		v, err := hgvs.Parse(description)
+		require.NoError(t, err, description)
+
+		assert.Equal(t, description, v.String())
+	}
+
+	for _, description := range []string{
+		"41245466G>A",
+		"NC_000017.10:c.41245466G>A",
+		"NC_000017.10:g.(41245466_41245468)del",
+		"NC_000017.10:g.41245468_41245466del",
+		"NC_000017.10:g.41245466GA>TC",
+		"NC_000017.10:g.41245466_41245468insT",
+		"NC_000017.10:g.41245466_41245468delGA",
+		"NC_000017.10:g.41245466inv",
+		"NC_000017.10:g.41245466G>X",
+		"NC_000017.10:g.[41245466G>A;41245468del]",
+	} {
+		_, err := hgvs.Parse(description)
+		assert.Error(t, err, description)
+	}
+}
+
+func TestAccession(t *testing.T) {
+	accession, err := hgvs.Accession(types.ReferenceGRCh37, types.Chr17)
+	require.NoError(t, err)
+
+	assert.Equal(t, "NC_000017.10", accession)
+
+	accession, err = hgvs.Accession(types.ReferenceGRCh38, types.ChrX)
+	require.NoError(t, err)
+
+	assert.Equal(t, "NC_000023.11", accession)
+
+	accession, err = hgvs.Accession(types.ReferenceTelomereToTelomereV2, types.ChrY)
+	require.NoError(t, err)
+
+	assert.Equal(t, "NC_060948.1", accession)
+
+	_, err = hgvs.Accession(types.ReferenceGRCh38, types.ChrPAR)
+	assert.Error(t, err)
+
+	chromosome, references, err := hgvs.ResolveAccession("NC_000017.11")
+	require.NoError(t, err)
+
+	assert.Equal(t, types.Chr17, chromosome)
+	assert.Equal(t, []types.Reference{types.ReferenceGRCh38}, references)
+
+	chromosome, references, err = hgvs.ResolveAccession("NC_012920.1")
+	require.NoError(t, err)
+
+	assert.Equal(t, types.ChrMT, chromosome)
+	assert.ElementsMatch(t, []types.Reference{types.ReferenceGRCh37, types.ReferenceGRCh38, types.ReferenceTelomereToTelomereV2}, references)
+
+	_, _, err = hgvs.ResolveAccession("NM_007294.4")
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/zymatik-com/genobase/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// Locus is a position on a chromosome.
+type Locus struct {
+	Chromosome types.Chromosome
+	Position   int64
+}
+
+// BatchResult is the result of lifting a single position of a batch.
+type BatchResult struct {
+	Result *LiftResult // Lifted position, nil if it could not be lifted.
+	Err    error       // Reason the position could not be lifted.
+}
+
+// WithWorkers lifts the chromosomes of a batch concurrently, using up to the
+// given number of goroutines (the source must be safe for concurrent use). It
+// only affects LiftBatch.
+func WithWorkers(workers int) LiftOption {
+	return func(opts *liftOptions) {
+		opts.workers = workers
+	}
+}
+
+// LiftBatch lifts many positions at once, returning a result for each in the
+// same order as the input. The positions of each chromosome are sorted and swept
+// in order, so consecutive positions within the same chain and alignment block
+// are lifted without querying the source again.
+//
+// Results are the same as calling Lift for each position, except where chains
+// overlap in sources that do not implement MultiChainSource, in which case the
+// chain found for an earlier position continues to be used while it spans later
+// positions. Positions that cannot be lifted have their reason recorded in the
+// result, while other errors (eg. a database failure, or the context being
+// cancelled) abort the batch.
+func LiftBatch(ctx context.Context, src ChainSource, from, to types.Reference, loci []Locus, opts ...LiftOption) ([]BatchResult, error) {
+	options := liftOptions{
+		workers: 1,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	byChromosome := make(map[types.Chromosome][]int)
+	for i, locus := range loci {
+		byChromosome[locus.Chromosome] = append(byChromosome[locus.Chromosome], i)
+	}
+
+	chromosomes := make([]types.Chromosome, 0, len(byChromosome))
+	for chromosome := range byChromosome {
+		chromosomes = append(chromosomes, chromosome)
+	}
+
+	sort.Slice(chromosomes, func(i, j int) bool {
+		return chromosomes[i] < chromosomes[j]
+	})
+
+	results := make([]BatchResult, len(loci))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(options.workers, 1))
+
+	for _, chromosome := range chromosomes {
+		chromosome := chromosome
+		indices := byChromosome[chromosome]
+
+		g.Go(func() error {
+			return liftChromosome(ctx, src, from, to, chromosome, loci, indices, results, options)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// liftChromosome lifts the positions (with the given indices) of a single
+// chromosome in a batch, storing the results at the same indices.
+func liftChromosome(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, loci []Locus, indices []int, results []BatchResult, options liftOptions) error {
+	sort.Slice(indices, func(i, j int) bool {
+		if loci[indices[i]].Position != loci[indices[j]].Position {
+			return loci[indices[i]].Position < loci[indices[j]].Position
+		}
+
+		return indices[i] < indices[j]
+	})
+
+	// Sources that can return every chain overlapping a region are queried once
+	// for the whole chromosome.
+	var chains []types.Chain
+	multiSrc, multi := src.(MultiChainSource)
+	if multi {
+		var err error
+		chains, err = multiSrc.GetChains(ctx, from, to, chromosome, loci[indices[0]].Position, loci[indices[len(indices)-1]].Position)
+		if err != nil && UnmappedReason(withUnmappedReason(err, ErrDeleted)) == nil {
+			return fmt.Errorf("could not get chains: %w", err)
+		}
+
+		sort.SliceStable(chains, func(i, j int) bool {
+			return chains[i].RefStart < chains[j].RefStart
+		})
+	}
+
+	// The chains spanning the current position (multi), or the last chain found.
+	var active []*types.Chain
+	var next int
+
+	// The last alignment block used for each chain.
+	alignments := make(map[int64]*types.Alignment)
+
+	lifted := make([]LiftResult, len(indices))
+	for k, i := range indices {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		position := loci[i].Position
+
+		var chain *types.Chain
+		if multi {
+			for ; next < len(chains) && chains[next].RefStart < position; next++ {
+				active = append(active, &chains[next])
+			}
+
+			spanning := active[:0]
+			for _, chain := range active {
+				if chain.RefEnd >= position {
+					spanning = append(spanning, chain)
+				}
+			}
+			active = spanning
+
+			// Where chains overlap, leave it to the source to choose between them.
+			if len(active) == 1 {
+				chain = active[0]
+			}
+		} else if len(active) > 0 && active[0].RefStart < position && active[0].RefEnd >= position {
+			chain = active[0]
+		}
+
+		if chain == nil {
+			var err error
+			chain, err = src.GetChain(ctx, from, to, chromosome, position)
+			if err != nil {
+				err = fmt.Errorf("could not get chain: %w", withUnmappedReason(err, ErrDeleted))
+				if UnmappedReason(err) == nil {
+					return err
+				}
+
+				results[i].Err = err
+				continue
+			}
+
+			if !multi {
+				active = []*types.Chain{chain}
+			}
+		}
+
+		offset := position - chain.RefStart
+		if alignment, ok := alignments[chain.ID]; ok && offset > alignment.RefOffset && offset <= alignment.RefOffset+alignment.Size {
+			lifted[k] = liftWithAlignment(to, chain, alignment, position, 0)
+			results[i].Result = &lifted[k]
+			continue
+		}
+
+		alignment, distance, err := findAlignment(ctx, src, chain, position, options)
+		if err != nil {
+			if UnmappedReason(err) == nil {
+				return err
+			}
+
+			results[i].Err = err
+			continue
+		}
+
+		alignments[chain.ID] = alignment
+		lifted[k] = liftWithAlignment(to, chain, alignment, position, distance)
+		results[i].Result = &lifted[k]
+	}
+
+	return nil
+}
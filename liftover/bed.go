@@ -0,0 +1,294 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/compress"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+// LiftBED lifts every feature in the BED file read from r (optionally
+// compressed) and writes the lifted features to w, and any features that could
+// not be lifted to rejects (each preceded by a comment with the reason).
+//
+// The strand, thick region (BED8) and blocks (BED12) of each feature are lifted
+// along with it. Features whose thick region or blocks are not lifted to the
+// same chromosome and strand, or in the same order, are rejected as split.
+// Zero-length features (eg. insertion points) are lifted using the base that
+// follows them. Header lines ("#", "track" and "browser") are copied to both outputs.
+func LiftBED(ctx context.Context, src ChainSource, from, to types.Reference, r io.Reader, w, rejects io.Writer, opts ...FeatureOption) (*FeatureStats, error) {
+	var options featureOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	dr, err := compress.Decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress bed: %w", err)
+	}
+	defer dr.Close()
+
+	fw, err := newFeatureWriter(w, rejects, options.compression)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(dr)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read bed: %w", err)
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			if err := fw.header(line); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		fields, reason, err := liftBEDRecord(ctx, src, from, to, line, options.intervalOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		if reason != "" {
+			err = fw.rejected(line, reason)
+		} else {
+			err = fw.lifted(strings.Join(fields, "\t"))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+
+	return fw.stats, nil
+}
+
+// liftBEDRecord lifts a BED record, returning the lifted fields or the reason
+// it could not be lifted.
+func liftBEDRecord(ctx context.Context, src ChainSource, from, to types.Reference, line string, opts []IntervalOption) ([]string, string, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 3 {
+		fields = strings.Fields(line)
+	}
+
+	if len(fields) < 3 {
+		return nil, RejectInvalidFeature, nil
+	}
+
+	start, startErr := strconv.ParseInt(fields[1], 10, 64)
+	end, endErr := strconv.ParseInt(fields[2], 10, 64)
+	if startErr != nil || endErr != nil {
+		return nil, RejectInvalidFeature, nil
+	}
+
+	chromosome := names.Chromosome(fields[0])
+
+	if start == end {
+		return liftBEDInsertionPoint(ctx, src, from, to, chromosome, fields, start)
+	}
+
+	// BED intervals are 0-based and half-open.
+	segment, reason, err := liftFeatureInterval(ctx, src, from, to, chromosome, start+1, end, opts)
+	if segment == nil {
+		return nil, reason, err
+	}
+
+	// liftPart lifts part of the feature, which must be lifted alongside it.
+	liftPart := func(start, end int64) (int64, int64, string, error) {
+		part, reason, err := liftFeatureInterval(ctx, src, from, to, chromosome, start+1, end, opts)
+		if part == nil {
+			return 0, 0, reason, err
+		}
+
+		if part.Chromosome != segment.Chromosome || part.Strand != segment.Strand {
+			return 0, 0, ErrSplit.Error(), nil
+		}
+
+		return part.Start - 1, part.End, "", nil
+	}
+
+	lifted := make([]string, len(fields))
+	copy(lifted, fields)
+
+	liftedStart, liftedEnd := segment.Start-1, segment.End
+	lifted[0] = formatChromosome(fields[0], segment.Chromosome)
+	lifted[1] = strconv.FormatInt(liftedStart, 10)
+	lifted[2] = strconv.FormatInt(liftedEnd, 10)
+
+	if len(fields) >= 6 && segment.Strand == "-" {
+		lifted[5] = flipStrand(fields[5])
+	}
+
+	if len(fields) >= 8 {
+		thickStart, startErr := strconv.ParseInt(fields[6], 10, 64)
+		thickEnd, endErr := strconv.ParseInt(fields[7], 10, 64)
+		if startErr != nil || endErr != nil {
+			return nil, RejectInvalidFeature, nil
+		}
+
+		// Features without a thick region (eg. non-coding transcripts) have an
+		// empty thick region at their start.
+		liftedThickStart, liftedThickEnd := liftedStart, liftedStart
+		if thickEnd > thickStart {
+			liftedThickStart, liftedThickEnd, reason, err = liftPart(thickStart, thickEnd)
+			if reason != "" || err != nil {
+				return nil, reason, err
+			}
+		}
+
+		lifted[6] = strconv.FormatInt(liftedThickStart, 10)
+		lifted[7] = strconv.FormatInt(liftedThickEnd, 10)
+	}
+
+	if len(fields) >= 12 {
+		blockSizes, sizesErr := parseBEDList(fields[10])
+		blockStarts, startsErr := parseBEDList(fields[11])
+		blockCount, countErr := strconv.Atoi(fields[9])
+		if sizesErr != nil || startsErr != nil || countErr != nil || len(blockSizes) != blockCount || len(blockStarts) != blockCount {
+			return nil, RejectInvalidFeature, nil
+		}
+
+		type block struct{ start, end int64 }
+		blocks := make([]block, 0, blockCount)
+		for i := range blockSizes {
+			blockStart, blockEnd, reason, err := liftPart(start+blockStarts[i], start+blockStarts[i]+blockSizes[i])
+			if reason != "" || err != nil {
+				return nil, reason, err
+			}
+
+			blocks = append(blocks, block{start: blockStart, end: blockEnd})
+		}
+
+		if segment.Strand == "-" {
+			for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+				blocks[i], blocks[j] = blocks[j], blocks[i]
+			}
+		}
+
+		// The blocks must still span the feature, in order, without overlapping.
+		if len(blocks) > 0 && (blocks[0].start != liftedStart || blocks[len(blocks)-1].end != liftedEnd) {
+			return nil, ErrSplit.Error(), nil
+		}
+
+		sizes := make([]string, len(blocks))
+		starts := make([]string, len(blocks))
+		for i, b := range blocks {
+			if i > 0 && b.start < blocks[i-1].end {
+				return nil, ErrSplit.Error(), nil
+			}
+
+			sizes[i] = strconv.FormatInt(b.end-b.start, 10)
+			starts[i] = strconv.FormatInt(b.start-liftedStart, 10)
+		}
+
+		lifted[10] = formatBEDList(fields[10], sizes)
+		lifted[11] = formatBEDList(fields[11], starts)
+	}
+
+	return lifted, "", nil
+}
+
+// liftBEDInsertionPoint lifts a zero-length BED record (eg. an insertion point)
+// using the base following it, returning the lifted fields or the reason it
+// could not be lifted.
+func liftBEDInsertionPoint(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, fields []string, point int64) ([]string, string, error) {
+	// The base following the point is at the same 0-based position.
+	result, reason, err := liftFeaturePosition(ctx, src, from, to, chromosome, point+1)
+	if result == nil {
+		return nil, reason, err
+	}
+
+	// The point is before the lifted base, or after it on the negative strand.
+	liftedPoint := result.Position - 1
+	if result.Strand == "-" {
+		liftedPoint = result.Position
+	}
+
+	lifted := make([]string, len(fields))
+	copy(lifted, fields)
+
+	lifted[0] = formatChromosome(fields[0], result.Chromosome)
+	lifted[1] = strconv.FormatInt(liftedPoint, 10)
+	lifted[2] = strconv.FormatInt(liftedPoint, 10)
+
+	if len(fields) >= 6 && result.Strand == "-" {
+		lifted[5] = flipStrand(fields[5])
+	}
+
+	// Any thick region (or blocks) is also empty.
+	if len(fields) >= 8 {
+		lifted[6] = lifted[1]
+		lifted[7] = lifted[1]
+	}
+
+	return lifted, "", nil
+}
+
+// parseBEDList parses a comma separated list of integers (with an optional
+// trailing comma).
+func parseBEDList(field string) ([]int64, error) {
+	field = strings.TrimSuffix(field, ",")
+	if field == "" {
+		return nil, nil
+	}
+
+	var values []int64
+	for _, part := range strings.Split(field, ",") {
+		value, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// formatBEDList formats a comma separated list, keeping any trailing comma of
+// the original list.
+func formatBEDList(original string, values []string) string {
+	list := strings.Join(values, ",")
+	if strings.HasSuffix(original, ",") {
+		list += ","
+	}
+
+	return list
+}
@@ -0,0 +1,311 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/zymatik-com/genobase/types"
+)
+
+// CacheStats are the hit and miss statistics of a CachedSource.
+type CacheStats struct {
+	ChainHits       uint64 // GetChain calls answered from the cache.
+	ChainMisses     uint64 // GetChain calls passed to the underlying source.
+	AlignmentHits   uint64 // GetAlignment calls answered from the cache.
+	AlignmentMisses uint64 // GetAlignment calls passed to the underlying source.
+	Evictions       uint64 // Entries evicted to keep the cache within its size.
+	Entries         int    // Number of chains and alignment blocks cached.
+}
+
+// CachedSource is a ChainSource that caches the chains and alignment blocks
+// returned by another source (eg. a DBSource), evicting the least recently used
+// entries once the cache is full. Chains and alignment blocks both answer any
+// later lookup of a position (or offset) that the source would have answered
+// with the same chain (or block). For chains, this is the part of the chain that
+// no other chain overlaps, which is only known if the source is a
+// MultiChainSource, otherwise chains are cached for the positions they were
+// looked up for. Errors are not cached. It is safe for concurrent use (if the
+// underlying source is).
+type CachedSource struct {
+	src  ChainSource
+	size int
+
+	mu  sync.Mutex
+	lru *list.List
+	// chains are the cached chains of each chromosome, ordered by position.
+	chains map[chainRegion][]*list.Element
+	// alignments are the cached alignment blocks of each chain, ordered by offset.
+	alignments map[int64][]*list.Element
+	stats      CacheStats
+}
+
+type chainRegion struct {
+	from, to   types.Reference
+	chromosome types.Chromosome
+}
+
+// chainEntry is a chain, and the range of positions (inclusive) for which the
+// source returns it.
+type chainEntry struct {
+	region chainRegion
+	start  int64
+	end    int64
+	chain  types.Chain
+}
+
+// alignmentEntry is an alignment block, and the range of offsets for which it
+// is the first block ending at or after the offset.
+type alignmentEntry struct {
+	chainID   int64
+	start     int64
+	end       int64
+	alignment types.Alignment
+}
+
+// NewCachedSource creates a new ChainSource that caches up to size chains and
+// alignment blocks from the given source.
+func NewCachedSource(src ChainSource, size int) *CachedSource {
+	return &CachedSource{
+		src:        src,
+		size:       max(size, 1),
+		lru:        list.New(),
+		chains:     make(map[chainRegion][]*list.Element),
+		alignments: make(map[int64][]*list.Element),
+	}
+}
+
+// Pairs returns the pairs of the underlying source (which are not cached).
+func (s *CachedSource) Pairs(ctx context.Context) ([]Hop, error) {
+	return s.src.Pairs(ctx)
+}
+
+// GetChain returns the chain for the given chromosome and position.
+func (s *CachedSource) GetChain(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error) {
+	region := chainRegion{from: from, to: to, chromosome: chromosome}
+
+	s.mu.Lock()
+	if element := s.findChain(region, position); element != nil {
+		s.lru.MoveToFront(element)
+		s.stats.ChainHits++
+
+		chain := element.Value.(*chainEntry).chain
+		s.mu.Unlock()
+
+		return &chain, nil
+	}
+	s.stats.ChainMisses++
+	s.mu.Unlock()
+
+	chain, err := s.src.GetChain(ctx, from, to, chromosome, position)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := s.exclusiveRange(ctx, from, to, chain, position)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another lookup may have cached it in the meantime.
+	if s.findChain(region, position) != nil {
+		return chain, nil
+	}
+
+	element := s.lru.PushFront(&chainEntry{region: region, start: start, end: end, chain: *chain})
+
+	elements := s.chains[region]
+	i := searchChains(elements, start)
+	elements = append(elements, nil)
+	copy(elements[i+1:], elements[i:])
+	elements[i] = element
+	s.chains[region] = elements
+
+	s.evict()
+
+	return chain, nil
+}
+
+// exclusiveRange returns the range of positions around the given position for
+// which the source will return the same chain, the part of the chain that no
+// other chain overlaps (or just the position itself, if it is overlapped, or
+// the source can not tell us about the other chains).
+func (s *CachedSource) exclusiveRange(ctx context.Context, from, to types.Reference, chain *types.Chain, position int64) (int64, int64, error) {
+	multiSrc, ok := s.src.(MultiChainSource)
+	if !ok {
+		return position, position, nil
+	}
+
+	overlapping, err := multiSrc.GetChains(ctx, from, to, chain.RefName, chain.RefStart, chain.RefEnd)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start, end := chain.RefStart, chain.RefEnd
+	for _, other := range overlapping {
+		switch {
+		case other.ID == chain.ID:
+			// The chain itself.
+		case other.RefStart <= position && other.RefEnd >= position:
+			return position, position, nil
+		case other.RefEnd < position:
+			start = max(start, other.RefEnd+1)
+		default:
+			end = min(end, other.RefStart-1)
+		}
+	}
+
+	return start, end, nil
+}
+
+// GetAlignment returns the first alignment block in the given chain that ends
+// at or after the given offset.
+func (s *CachedSource) GetAlignment(ctx context.Context, chainID int64, offset int64) (*types.Alignment, error) {
+	s.mu.Lock()
+	if element := s.findAlignment(chainID, offset); element != nil {
+		entry := element.Value.(*alignmentEntry)
+		if entry.start <= offset {
+			s.lru.MoveToFront(element)
+			s.stats.AlignmentHits++
+
+			alignment := entry.alignment
+			s.mu.Unlock()
+
+			return &alignment, nil
+		}
+	}
+	s.stats.AlignmentMisses++
+	s.mu.Unlock()
+
+	alignment, err := s.src.GetAlignment(ctx, chainID, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Offsets within the block, or in the gap before it (down to the offset that
+	// was looked up), are all answered with this block.
+	start := min(offset, alignment.RefOffset+1)
+	end := alignment.RefOffset + alignment.Size
+
+	if element := s.findAlignment(chainID, end); element != nil {
+		if entry := element.Value.(*alignmentEntry); entry.end == end {
+			entry.start = min(entry.start, start)
+			return alignment, nil
+		}
+	}
+
+	element := s.lru.PushFront(&alignmentEntry{chainID: chainID, start: start, end: end, alignment: *alignment})
+
+	elements := s.alignments[chainID]
+	i := searchAlignments(elements, end)
+	elements = append(elements, nil)
+	copy(elements[i+1:], elements[i:])
+	elements[i] = element
+	s.alignments[chainID] = elements
+
+	s.evict()
+
+	return alignment, nil
+}
+
+// Stats returns the hit and miss statistics of the cache.
+func (s *CachedSource) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Entries = s.lru.Len()
+
+	return stats
+}
+
+// findChain returns the cached chain of the region whose range includes the
+// given position, if any.
+func (s *CachedSource) findChain(region chainRegion, position int64) *list.Element {
+	elements := s.chains[region]
+	if i := searchChains(elements, position+1); i > 0 {
+		if entry := elements[i-1].Value.(*chainEntry); entry.end >= position {
+			return elements[i-1]
+		}
+	}
+
+	return nil
+}
+
+// searchChains returns the index of the first chain whose range starts at or
+// after the given position.
+func searchChains(elements []*list.Element, position int64) int {
+	return sort.Search(len(elements), func(i int) bool {
+		return elements[i].Value.(*chainEntry).start >= position
+	})
+}
+
+// findAlignment returns the cached alignment block of the chain with the lowest
+// end at or after the given offset, if any.
+func (s *CachedSource) findAlignment(chainID int64, offset int64) *list.Element {
+	elements := s.alignments[chainID]
+	if i := searchAlignments(elements, offset); i < len(elements) {
+		return elements[i]
+	}
+
+	return nil
+}
+
+// searchAlignments returns the index of the first alignment block that ends
+// at or after the given offset.
+func searchAlignments(elements []*list.Element, offset int64) int {
+	return sort.Search(len(elements), func(i int) bool {
+		return elements[i].Value.(*alignmentEntry).end >= offset
+	})
+}
+
+// evict removes the least recently used entries until the cache fits.
+func (s *CachedSource) evict() {
+	for s.lru.Len() > s.size {
+		element := s.lru.Back()
+		s.lru.Remove(element)
+		s.stats.Evictions++
+
+		switch entry := element.Value.(type) {
+		case *chainEntry:
+			elements := s.chains[entry.region]
+			i := searchChains(elements, entry.start)
+
+			elements = append(elements[:i], elements[i+1:]...)
+			if len(elements) == 0 {
+				delete(s.chains, entry.region)
+			} else {
+				s.chains[entry.region] = elements
+			}
+		case *alignmentEntry:
+			elements := s.alignments[entry.chainID]
+			i := searchAlignments(elements, entry.end)
+
+			elements = append(elements[:i], elements[i+1:]...)
+			if len(elements) == 0 {
+				delete(s.alignments, entry.chainID)
+			} else {
+				s.alignments[entry.chainID] = elements
+			}
+		}
+	}
+}
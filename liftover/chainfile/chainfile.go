@@ -16,17 +16,15 @@
 package chainfile
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
-	"strconv"
-	"strings"
+	"sort"
 
 	"github.com/Workiva/go-datastructures/augmentedtree"
 	"github.com/zymatik-com/genobase/types"
-	"github.com/zymatik-com/nucleo/names"
 )
 
 // Chain represents a single Chain in a Chain file.
@@ -62,6 +60,52 @@ func (c *Chain) ID() uint64 {
 	return uint64(c.ID_)
 }
 
+func (c *Chain) toType() *types.Chain {
+	return &types.Chain{
+		ID:          c.ID_,
+		Score:       c.Score,
+		RefName:     c.RefName,
+		RefSize:     c.RefSize,
+		RefStrand:   c.RefStrand,
+		RefStart:    c.RefStart,
+		RefEnd:      c.RefEnd,
+		QueryName:   c.QueryName,
+		QuerySize:   c.QuerySize,
+		QueryStrand: c.QueryStrand,
+		QueryStart:  c.QueryStart,
+		QueryEnd:    c.QueryEnd,
+	}
+}
+
+// aligns returns whether the given (1-based) position falls within an
+// alignment block of the chain.
+func (c *Chain) aligns(position int64) bool {
+	offset := position - c.RefStart
+
+	for _, interval := range c.Alignments.Query(&Interval{Start: offset, End: offset}) {
+		if alignment := interval.(*Alignment); offset > alignment.RefOffset {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SortedAlignments returns the alignment blocks of the chain, ordered by their
+// offset from the start of the chain.
+func (c *Chain) SortedAlignments() []*Alignment {
+	alignments := make([]*Alignment, 0, c.Alignments.Len())
+	c.Alignments.Traverse(func(interval augmentedtree.Interval) {
+		alignments = append(alignments, interval.(*Alignment))
+	})
+
+	sort.Slice(alignments, func(i, j int) bool {
+		return alignments[i].RefOffset < alignments[j].RefOffset
+	})
+
+	return alignments
+}
+
 // Alignment represents an Alignment block within a chain.
 type Alignment struct {
 	RefOffset   int64 `db:"ref_offset"`   // Offset of the aligned block in the reference chromosome from the start of the chain.
@@ -110,8 +154,20 @@ func (i *Interval) ID() uint64 {
 	return h.Sum64()
 }
 
+// Pair identifies the reference genome assemblies that chains map between.
+type Pair struct {
+	From types.Reference // Source (reference) genome assembly.
+	To   types.Reference // Target (query) genome assembly.
+}
+
 // ChainFile represents a chain file.
 type ChainFile struct {
+	// From is the source genome assembly of the chain file. If unset, the chain
+	// file will be used to lift from any assembly.
+	From types.Reference
+	// To is the target genome assembly of the chain file. If unset, the chain
+	// file will be used to lift to any assembly.
+	To types.Reference
 	// ChainsByChromosome maps a chromosome name to an interval tree of chains.
 	ChainsByChromosome map[types.Chromosome]augmentedtree.Tree
 	// ChainByID maps a chain ID to a chain.
@@ -119,164 +175,140 @@ type ChainFile struct {
 }
 
 // Read loads a chain file from an io.Reader.
-func Read(reader io.Reader) (*ChainFile, error) {
+func Read(reader io.Reader, opts ...ReadOption) (*ChainFile, error) {
 	chainFile := &ChainFile{
 		ChainsByChromosome: make(map[types.Chromosome]augmentedtree.Tree),
 		ChainByID:          make(map[int64]*Chain),
 	}
 
-	var currentChain *Chain
-	var refOffset, queryOffset int64
+	r := NewReader(reader, opts...)
+	for {
+		chain, err := r.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
 
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+			return nil, err
 		}
 
-		fields := strings.Fields(line)
-
-		if strings.HasPrefix(line, "chain") {
-			if currentChain != nil {
-				tree, exists := chainFile.ChainsByChromosome[currentChain.RefName]
-				if !exists {
-					tree = augmentedtree.New(1)
-				}
-				tree.Add(currentChain)
-				chainFile.ChainsByChromosome[currentChain.RefName] = tree
-				chainFile.ChainByID[currentChain.ID_] = currentChain
-			}
+		chainFile.add(chain)
+	}
 
-			if len(fields) < 12 {
-				return nil, fmt.Errorf("invalid chain line: %s", line)
-			}
+	return chainFile, nil
+}
 
-			currentChain = &Chain{
-				Score:       parseField(fields[1]),
-				RefName:     names.Chromosome(fields[2]),
-				RefSize:     parseField(fields[3]),
-				RefStrand:   fields[4],
-				RefStart:    parseField(fields[5]),
-				RefEnd:      parseField(fields[6]),
-				QueryName:   names.Chromosome(fields[7]),
-				QuerySize:   parseField(fields[8]),
-				QueryStrand: fields[9],
-				QueryStart:  parseField(fields[10]),
-				QueryEnd:    parseField(fields[11]),
-				ID_:         parseField(fields[12]),
-				Alignments:  augmentedtree.New(1),
-			}
+// add adds a chain to the chain file.
+func (cf *ChainFile) add(chain *Chain) {
+	tree, exists := cf.ChainsByChromosome[chain.RefName]
+	if !exists {
+		tree = augmentedtree.New(1) // Create a new tree for this chromosome
+	}
+	tree.Add(chain)
+	cf.ChainsByChromosome[chain.RefName] = tree
+	cf.ChainByID[chain.ID_] = chain
+}
 
-			// Reset the offsets.
-			refOffset, queryOffset = 0, 0
-		} else if currentChain != nil {
-			// Parse an alignment block or the non-aligning region
-			if len(fields) == 1 {
-				size := parseField(fields[0])
-
-				currentChain.Alignments.Add(&Alignment{
-					RefOffset:   refOffset,
-					QueryOffset: queryOffset,
-					Size:        size,
-				})
-
-				refOffset += size
-				queryOffset += size
-			} else if len(fields) == 3 {
-				size := parseField(fields[0])
-				// Gap between this and the next block in the reference genome.
-				refGap := parseField(fields[1])
-				// Gap between this and the next block in the query genome.
-				queryGap := parseField(fields[2])
-
-				currentChain.Alignments.Add(&Alignment{
-					RefOffset:   refOffset,
-					QueryOffset: queryOffset,
-					Size:        size,
-				})
-
-				refOffset += size + refGap
-				queryOffset += size + queryGap
-			} else {
-				return nil, fmt.Errorf("invalid alignment line: %q", line)
-			}
-		}
+// Pairs returns the source and target genome assemblies of the chain file, or
+// nothing if they have not been set.
+func (cf *ChainFile) Pairs(ctx context.Context) ([]Pair, error) {
+	if cf.From == "" || cf.To == "" {
+		return nil, nil
 	}
 
-	if currentChain != nil {
-		tree, exists := chainFile.ChainsByChromosome[currentChain.RefName]
-		if !exists {
-			tree = augmentedtree.New(1) // Create a new tree for this chromosome
+	return []Pair{{From: cf.From, To: cf.To}}, nil
+}
+
+// GetChain returns the chain for the given chromosome and position. If the
+// position is covered by multiple chains, chains that align the position are
+// preferred over those where it falls in a gap, and then the highest scoring
+// chain is chosen.
+func (cf *ChainFile) GetChain(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error) {
+	intervals, err := cf.overlapping(from, to, chromosome, position, position)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *Chain
+	var bestAligned bool
+	for _, interval := range intervals {
+		chain := interval.(*Chain)
+		aligned := chain.aligns(position)
+
+		if best == nil || (aligned && !bestAligned) ||
+			(aligned == bestAligned && chain.Score > best.Score) {
+			best, bestAligned = chain, aligned
 		}
-		tree.Add(currentChain)
-		chainFile.ChainsByChromosome[currentChain.RefName] = tree
-		chainFile.ChainByID[currentChain.ID_] = currentChain
 	}
 
-	return chainFile, scanner.Err()
+	return best.toType(), nil
 }
 
-// GetChain returns the chain for the given chromosome and position.
-func (cf *ChainFile) GetChain(ctx context.Context, from types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error) {
+// GetChains returns all the chains overlapping the given chromosome region.
+func (cf *ChainFile) GetChains(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, start, end int64) ([]types.Chain, error) {
+	intervals, err := cf.overlapping(from, to, chromosome, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	chains := make([]types.Chain, 0, len(intervals))
+	for _, interval := range intervals {
+		chains = append(chains, *interval.(*Chain).toType())
+	}
+
+	return chains, nil
+}
+
+// overlapping returns the chains overlapping the given region (inclusive),
+// ordered by their start position.
+func (cf *ChainFile) overlapping(from, to types.Reference, chromosome types.Chromosome, start, end int64) (augmentedtree.Intervals, error) {
+	if (cf.From != "" && cf.From != from) || (cf.To != "" && cf.To != to) {
+		return nil, fmt.Errorf("chain file does not map from %s to %s: %w", from, to, ErrNoChain)
+	}
+
 	tree, ok := cf.ChainsByChromosome[chromosome]
 	if !ok {
-		return nil, fmt.Errorf("chromosome %s not found", chromosome)
+		return nil, fmt.Errorf("chromosome %s not found: %w", chromosome, ErrNoChain)
 	}
 
-	query := &Interval{Start: position, End: position}
+	query := &Interval{Start: start, End: end}
 	intervals := tree.Query(query)
 	if len(intervals) == 0 {
-		return nil, fmt.Errorf("position %d not found in chromosome %s", position, chromosome)
+		return nil, fmt.Errorf("region %d-%d not found in chromosome %s: %w", start, end, chromosome, ErrDeleted)
 	}
 
-	chain := intervals[0].(*Chain)
-
-	return &types.Chain{
-		ID:          chain.ID_,
-		Score:       chain.Score,
-		RefName:     chain.RefName,
-		RefSize:     chain.RefSize,
-		RefStrand:   chain.RefStrand,
-		RefStart:    chain.RefStart,
-		RefEnd:      chain.RefEnd,
-		QueryName:   chain.QueryName,
-		QuerySize:   chain.QuerySize,
-		QueryStrand: chain.QueryStrand,
-		QueryStart:  chain.QueryStart,
-		QueryEnd:    chain.QueryEnd,
-	}, nil
+	return intervals, nil
 }
 
-// GetAlignment returns the alignment for the given chain and offset from the
-// start of the chain.
+// GetAlignment returns the first alignment block in the given chain that ends
+// at or after the given offset from the start of the chain. If the offset falls
+// in a gap between two blocks, the following block is returned.
 func (cf *ChainFile) GetAlignment(ctx context.Context, chainID int64, offset int64) (*types.Alignment, error) {
 	chain, ok := cf.ChainByID[chainID]
 	if !ok {
 		return nil, fmt.Errorf("chain %d not found", chainID)
 	}
 
-	query := &Interval{Start: offset, End: offset}
+	chainSize := chain.RefEnd - chain.RefStart
 
-	intervals := chain.Alignments.Query(query)
-	if len(intervals) == 0 {
-		return nil, fmt.Errorf("offset %d not found in chain %d", offset, chainID)
-	}
+	// Gaps are usually small, so progressively widen the search window rather
+	// than querying for every remaining block in the chain.
+	for window := int64(0); ; window = max(2*window, 1024) {
+		query := &Interval{Start: offset, End: offset + window}
 
-	alignment := intervals[0].(*Alignment)
+		intervals := chain.Alignments.Query(query)
+		if len(intervals) > 0 {
+			alignment := intervals[0].(*Alignment)
 
-	return &types.Alignment{
-		RefOffset:   alignment.RefOffset,
-		QueryOffset: alignment.QueryOffset,
-		Size:        alignment.Size,
-	}, nil
-}
+			return &types.Alignment{
+				RefOffset:   alignment.RefOffset,
+				QueryOffset: alignment.QueryOffset,
+				Size:        alignment.Size,
+			}, nil
+		}
 
-func parseField(field string) int64 {
-	value, err := strconv.ParseInt(field, 10, 64)
-	if err != nil {
-		return -1
+		if offset+window >= chainSize {
+			return nil, fmt.Errorf("offset %d not found in chain %d: %w", offset, chainID, ErrGap)
+		}
 	}
-
-	return value
 }
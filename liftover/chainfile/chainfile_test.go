@@ -13,19 +13,23 @@
 package chainfile_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
-	"github.com/brentp/vcfgo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/zymatik-com/genobase/types"
 	"github.com/zymatik-com/nucleo/compress"
 	"github.com/zymatik-com/nucleo/liftover"
 	"github.com/zymatik-com/nucleo/liftover/chainfile"
-	"github.com/zymatik-com/nucleo/names"
+	"github.com/zymatik-com/nucleo/liftover/evaluate"
 )
 
 // A simple test to check if the liftover works as expected by validating the
@@ -54,26 +58,541 @@ func TestChainFile(t *testing.T) {
 	cf, err := chainfile.Read(dr)
 	require.NoError(t, err)
 
-	grch37SNPs, err := readClinVarSNPs("../../testdata/clinvar_GRCh37_20231230.vcf.gz")
+	grch37SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+	grch38SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh38_20231230.vcf.gz", types.ReferenceGRCh38)
+
+	report, err := evaluate.Evaluate(ctx, cf, grch37SNPs, grch38SNPs)
+	require.NoError(t, err)
+
+	assert.Greater(t, report.Concordant, 1000)
+	assert.Greater(t, report.Concordance(), 0.995)
+}
+
+func TestReader(t *testing.T) {
+	const chains = `chain 100 chr1 1000 + 100 200 chr1 1000 + 200 300 1
+100
+
+chain 200 chr2 1000 + 0 100 chr2 1000 - 0 100 2
+50 10 10
+40
+
+chain 300 chr1 1000 + 300 400 chr1 1000 + 500 600 3
+100
+`
+
+	t.Run("Next", func(t *testing.T) {
+		r := chainfile.NewReader(strings.NewReader(chains))
+
+		var ids []int64
+		for {
+			chain, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+
+			ids = append(ids, chain.ID_)
+
+			if chain.ID_ == 2 {
+				assert.Equal(t, types.Chromosome("2"), chain.RefName)
+				assert.Len(t, chain.SortedAlignments(), 2)
+			}
+		}
+
+		assert.Equal(t, []int64{1, 2, 3}, ids)
+	})
+
+	t.Run("Chromosomes", func(t *testing.T) {
+		r := chainfile.NewReader(strings.NewReader(chains), chainfile.WithChromosomes("chr1"))
+
+		chain, err := r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), chain.ID_)
+
+		chain, err = r.Next()
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), chain.ID_)
+
+		_, err = r.Next()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		data := readChainFileData(t, "../../testdata/GRCh37_to_GRCh38.chain.gz")
+
+		cf, err := chainfile.Read(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		subset, err := chainfile.Read(bytes.NewReader(data), chainfile.WithChromosomes("22"))
+		require.NoError(t, err)
+
+		assert.Len(t, subset.ChainsByChromosome, 1)
+		assert.Equal(t, cf.ChainsByChromosome["22"].Len(), subset.ChainsByChromosome["22"].Len())
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		require.NoError(t, chainfile.Validate(strings.NewReader(`chain 100 chr1 1000 + 100 200 chr1 1000 + 200 310 1
+50 0 10
+50
+`)))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		err := chainfile.Validate(strings.NewReader(`chain 100 chr1 1000 + 100 200 chr1 1000 + 200 300
+100
+
+chain 100 chr1 1000 x 100 200 chr1 1000 + 200 300 2
+100
+
+chain 100 chr1 1000 + 100 200 chr1 1000 + 200 300 3
+50 1O 0
+50
+
+chain 100 chr1 1000 + 100 200 chr1 1000 + 200 300 4
+90
+
+chain 100 chr1 1000 + 100 200 chr1 1000 + 200 300 4
+100
+`))
+		require.Error(t, err)
+
+		var parseErr *chainfile.ParseError
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, 1, parseErr.Line)
+
+		assert.Equal(t, []string{
+			"line 1: invalid chain line: chain 100 chr1 1000 + 100 200 chr1 1000 + 200 300",
+			"line 4: invalid strand \"x\"",
+			"line 8: invalid reference gap \"1O\"",
+			"line 11: alignment blocks span 90 reference bases, but the header spans 100",
+			"line 11: alignment blocks span 90 query bases, but the header spans 100",
+			"line 14: duplicate chain ID 4 (first seen on line 11)",
+		}, strings.Split(err.Error(), "\n"))
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		_, err := chainfile.Read(strings.NewReader(`chain 100 chr1 1000 + 100 200 chr1 1000 + 200 300 1
+-100
+`), chainfile.WithStrict())
+		require.EqualError(t, err, "line 1: negative alignment size -100")
+
+		// Only duplicated chain IDs are reported for the test chain files.
+		data := readChainFileData(t, "../../testdata/NCBI36_to_GRCh38.chain.gz")
+
+		err = chainfile.Validate(bytes.NewReader(data))
+		require.Error(t, err)
+
+		for _, line := range strings.Split(err.Error(), "\n") {
+			assert.Contains(t, line, "duplicate chain ID")
+		}
+	})
+}
+
+func TestReadAlignments(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		read     func(io.Reader) (*chainfile.ChainFile, error)
+		input    string
+		expected string
+	}{
+		{
+			name: "PAF",
+			read: chainfile.ReadPAF,
+			input: "q1\t500\t50\t140\t+\tchr1\t1000\t100\t200\t78\t100\t60\tAS:i:70\tcg:Z:20=1X19=10I20D40M\n" +
+				"q1\t500\t50\t140\t-\tchr2\t1000\t100\t200\t80\t100\t60\tcg:Z:40M10I20D40M\n",
+			expected: `chain 70 1 1000 + 100 200 Q1 500 + 50 140 1
+40 20 10
+40
+
+chain 80 2 1000 + 100 200 Q1 500 - 360 450 2
+40 20 10
+40
+
+`,
+		},
+		{
+			name: "PSL",
+			read: chainfile.ReadPSL,
+			input: `psLayout version 3
+
+match	mis- 	rep. 	N's	Q gap	Q gap	T gap	T gap	strand	Q        	Q   	Q    	Q  	T        	T   	T    	T  	block	blockSizes 	qStarts	 tStarts
+     	match	match	   	count	bases	count	bases	      	name     	size	start	end	name     	size	start	end	count
+---------------------------------------------------------------------------------------------------------------------------------------------------------------
+78	2	0	0	1	10	1	20	+	q1	500	50	140	chr1	1000	100	200	2	40,40,	50,100,	100,160,
+70	0	10	0	1	10	1	20	-	q1	500	50	140	chr2	1000	100	200	2	40,40,	360,410,	100,160,
+`,
+			expected: `chain 74 1 1000 + 100 200 Q1 500 + 50 140 1
+40 20 10
+40
+
+chain 73 2 1000 + 100 200 Q1 500 - 360 450 2
+40 20 10
+40
+
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := tt.read(strings.NewReader(tt.input))
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, chainfile.Write(&buf, cf))
+
+			assert.Equal(t, tt.expected, buf.String())
+
+			result, err := liftover.Lift(ctx, cf, types.ReferenceGRCh38, types.ReferenceTelomereToTelomereV2, "1", 101)
+			require.NoError(t, err)
+			assert.Equal(t, int64(51), result.Position)
+
+			// On the negative strand, the start of the target maps to the end of
+			// the query.
+			result, err = liftover.Lift(ctx, cf, types.ReferenceGRCh38, types.ReferenceTelomereToTelomereV2, "2", 101)
+			require.NoError(t, err)
+			assert.Equal(t, int64(140), result.Position)
+		})
+	}
+
+	t.Run("Missing CIGAR", func(t *testing.T) {
+		_, err := chainfile.ReadPAF(strings.NewReader("q1\t500\t50\t140\t+\tchr1\t1000\t100\t200\t78\t100\t60\n"))
+		require.EqualError(t, err, "line 1: missing cg:Z tag (alignments must include a CIGAR string)")
+	})
+}
+
+func TestCoverage(t *testing.T) {
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 chr1 1000 + 100 200 chr1 1000 + 200 310 1
+40 20 30
+40
+
+chain 100 chr1 1000 + 150 300 chr1 1000 + 500 650 2
+150
+
+chain 100 chr2 500 + 0 100 chr2 500 - 0 100 3
+100
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []chainfile.Coverage{
+		{Chromosome: "1", Size: 1000, Chains: 2, Aligned: 190, Gap: 10, Unmapped: 800},
+		{Chromosome: "2", Size: 500, Chains: 1, Aligned: 100, Gap: 0, Unmapped: 400},
+	}, cf.Coverage())
+
+	var mapped, unmapped bytes.Buffer
+	require.NoError(t, chainfile.WriteBED(&mapped, cf.MappedRegions()))
+	require.NoError(t, chainfile.WriteBED(&unmapped, cf.UnmappedRegions()))
+
+	assert.Equal(t, "1\t100\t140\n1\t150\t300\n2\t0\t100\n", mapped.String())
+	assert.Equal(t, "1\t0\t100\n1\t140\t150\n1\t300\t1000\n2\t100\t500\n", unmapped.String())
+
+	t.Run("GRCh37 To GRCh38", func(t *testing.T) {
+		cf := readChainFile(t, "../../testdata/GRCh37_to_GRCh38.chain.gz")
+
+		for _, coverage := range cf.Coverage() {
+			assert.Equal(t, coverage.Size, coverage.Aligned+coverage.Gap+coverage.Unmapped)
+
+			if coverage.Chromosome == "1" {
+				assert.Greater(t, float64(coverage.Aligned)/float64(coverage.Size), 0.85)
+			}
+		}
+	})
+}
+
+func TestNet(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 200 chr1 1000 + 100 200 chr1 1000 + 100 210 1
+40 20 30
+40
+
+chain 100 chr1 1000 + 120 180 chr1 1000 + 500 560 2
+60
+
+chain 50 chr1 1000 + 100 140 chr1 1000 + 800 840 3
+40
+`))
+	require.NoError(t, err)
+
+	netted := cf.Net()
+
+	var buf bytes.Buffer
+	require.NoError(t, chainfile.Write(&buf, netted))
+
+	// The second chain fills the gap in the first, and the third is entirely
+	// covered by the first.
+	assert.Equal(t, `chain 200 1 1000 + 100 200 1 1000 + 100 210 1
+40 20 30
+40
+
+chain 100 1 1000 + 140 160 1 1000 + 520 540 2
+20
+
+`, buf.String())
+
+	t.Run("GetChain", func(t *testing.T) {
+		// Both chains span the position, but only the second aligns it.
+		for _, cf := range []*chainfile.ChainFile{cf, netted} {
+			chain, err := cf.GetChain(ctx, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 150)
+			require.NoError(t, err)
+
+			assert.Equal(t, int64(2), chain.ID)
+		}
+
+		// All three chains align the position, the highest scoring is chosen.
+		chain, err := cf.GetChain(ctx, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 120)
+		require.NoError(t, err)
+
+		assert.Equal(t, int64(1), chain.ID)
+	})
+
+	t.Run("GRCh37 To GRCh38", func(t *testing.T) {
+		netted := readChainFile(t, "../../testdata/GRCh37_to_GRCh38.chain.gz").Net()
+
+		// No reference base is aligned by more than one chain.
+		aligned := make(map[types.Chromosome]int64)
+		for _, chain := range netted.SortedChains() {
+			for _, alignment := range chain.SortedAlignments() {
+				aligned[chain.RefName] += alignment.Size
+			}
+		}
+
+		for _, coverage := range netted.Coverage() {
+			assert.Equal(t, aligned[coverage.Chromosome], coverage.Aligned, coverage.Chromosome)
+		}
+
+		grch37SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+		grch38SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh38_20231230.vcf.gz", types.ReferenceGRCh38)
+
+		var foundInBoth, successFullyLifted int
+		for id, snp := range grch37SNPs.Variants {
+			if _, ok := grch38SNPs.Variants[id]; !ok {
+				continue
+			}
+
+			foundInBoth++
+
+			results, err := liftover.LiftAll(ctx, netted, types.ReferenceGRCh37, types.ReferenceGRCh38, snp.Chromosome, snp.Position)
+			if err != nil {
+				continue
+			}
+
+			assert.Len(t, results, 1)
+
+			if results[0].Chromosome == grch38SNPs.Variants[id].Chromosome && results[0].Position == grch38SNPs.Variants[id].Position {
+				successFullyLifted++
+			}
+		}
+
+		assert.Greater(t, successFullyLifted, 1000)
+		assert.Greater(t, float64(successFullyLifted)/float64(foundInBoth), 0.995)
+	})
+}
+
+func TestWrite(t *testing.T) {
+	for _, path := range []string{
+		"../../testdata/GRCh37_to_GRCh38.chain.gz",
+		"../../testdata/NCBI36_to_GRCh38.chain.gz",
+	} {
+		t.Run(path, func(t *testing.T) {
+			cf := readChainFile(t, path)
+
+			var buf bytes.Buffer
+			require.NoError(t, chainfile.Write(&buf, cf))
+
+			roundTripped, err := chainfile.Read(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+
+			var roundTrippedBuf bytes.Buffer
+			require.NoError(t, chainfile.Write(&roundTrippedBuf, roundTripped))
+
+			assert.Equal(t, len(cf.SortedChains()), len(roundTripped.SortedChains()))
+			assert.Equal(t, buf.String(), roundTrippedBuf.String())
+		})
+	}
+}
+
+func TestInvert(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Negative Strand", func(t *testing.T) {
+		cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 100 200 2 500 - 50 160 1
+40 10 20
+50
+`))
+		require.NoError(t, err)
+
+		inverted, err := cf.Invert()
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, chainfile.Write(&buf, inverted))
+
+		assert.Equal(t, `chain 100 2 500 + 340 450 1 1000 - 800 900 1
+50 20 10
+40
+
+`, buf.String())
+
+		for _, position := range []int64{101, 120, 140, 151, 200} {
+			result, err := liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", position)
+			require.NoError(t, err)
+
+			reverse, err := liftover.Lift(ctx, inverted, types.ReferenceGRCh38, types.ReferenceGRCh37, result.Chromosome, result.Position)
+			require.NoError(t, err)
+
+			assert.Equal(t, types.Chromosome("1"), reverse.Chromosome)
+			assert.Equal(t, position, reverse.Position)
+		}
+	})
+
+	t.Run("Assemblies", func(t *testing.T) {
+		cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 100 200 2 500 + 50 150 1
+100
+`))
+		require.NoError(t, err)
+
+		cf.From, cf.To = types.ReferenceGRCh37, types.ReferenceGRCh38
+
+		inverted, err := cf.Invert()
+		require.NoError(t, err)
+
+		pairs, err := inverted.Pairs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []chainfile.Pair{{From: types.ReferenceGRCh38, To: types.ReferenceGRCh37}}, pairs)
+
+		_, err = liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceTelomereToTelomereV2, "1", 150)
+		require.ErrorIs(t, err, chainfile.ErrNoChain)
+	})
+
+	for _, path := range []string{
+		"../../testdata/GRCh37_to_GRCh38.chain.gz",
+		"../../testdata/NCBI36_to_GRCh38.chain.gz",
+	} {
+		t.Run(path, func(t *testing.T) {
+			cf := readChainFile(t, path)
+
+			inverted, err := cf.Invert()
+			require.NoError(t, err)
+
+			roundTripped, err := inverted.Invert()
+			require.NoError(t, err)
+
+			var buf, roundTrippedBuf bytes.Buffer
+			require.NoError(t, chainfile.Write(&buf, cf))
+			require.NoError(t, chainfile.Write(&roundTrippedBuf, roundTripped))
+
+			assert.Equal(t, buf.String(), roundTrippedBuf.String())
+		})
+	}
+}
+
+func TestCompose(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Simple", func(t *testing.T) {
+		a, err := chainfile.Read(strings.NewReader(`chain 100 1 100 + 0 100 2 100 + 0 100 1
+40 10 10
+50
+`))
+		require.NoError(t, err)
+
+		b, err := chainfile.Read(strings.NewReader(`chain 100 2 100 + 20 80 3 200 - 10 70 1
+60
+`))
+		require.NoError(t, err)
+
+		composed, err := chainfile.Compose(a, b)
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, chainfile.Write(&buf, composed))
+
+		assert.Equal(t, `chain 50 1 100 + 20 80 3 200 - 10 70 1
+20 10 10
+30
+
+`, buf.String())
+	})
+
+	t.Run("Identity", func(t *testing.T) {
+		cf := readChainFile(t, "../../testdata/GRCh37_to_GRCh38.chain.gz")
+
+		inverted, err := cf.Invert()
+		require.NoError(t, err)
+
+		identity, err := chainfile.Compose(cf, inverted)
+		require.NoError(t, err)
+
+		grch37SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+
+		var lifted, unchanged int
+		for _, snp := range grch37SNPs.Variants {
+			// Regions duplicated in GRCh38 will also compose with other regions of
+			// GRCh37, so check all the chains.
+			results, err := liftover.LiftAll(ctx, identity, types.ReferenceGRCh37, types.ReferenceGRCh37, snp.Chromosome, snp.Position)
+			if err != nil {
+				continue
+			}
+
+			lifted++
+
+			for _, result := range results {
+				if result.Chromosome == snp.Chromosome && result.Position == snp.Position {
+					unchanged++
+					break
+				}
+			}
+		}
+
+		assert.Greater(t, lifted, 1000)
+		assert.Greater(t, float64(unchanged)/float64(lifted), 0.995)
+	})
+}
+
+func TestCompactChainFile(t *testing.T) {
+	ctx := context.Background()
+
+	data := readChainFileData(t, "../../testdata/GRCh37_to_GRCh38.chain.gz")
+
+	cf, err := chainfile.Read(bytes.NewReader(data))
 	require.NoError(t, err)
 
-	grch38SNPs, err := readClinVarSNPs("../../testdata/clinvar_GRCh38_20231230.vcf.gz")
+	compact, err := chainfile.ReadCompact(bytes.NewReader(data))
 	require.NoError(t, err)
 
+	grch37SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+	grch38SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh38_20231230.vcf.gz", types.ReferenceGRCh38)
+
 	var foundInBoth, successFullyLifted int
-	for _, snp := range grch37SNPs {
-		if _, ok := grch38SNPs[snp.id]; !ok {
+	for id, snp := range grch37SNPs.Variants {
+		expected, expectedErr := liftover.LiftAll(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, snp.Chromosome, snp.Position)
+		results, err := liftover.LiftAll(ctx, compact, types.ReferenceGRCh37, types.ReferenceGRCh38, snp.Chromosome, snp.Position)
+		if expectedErr != nil {
+			require.Error(t, err)
+			assert.Equal(t, liftover.UnmappedReason(expectedErr), liftover.UnmappedReason(err))
+		} else {
+			require.NoError(t, err)
+
+			assert.Equal(t, distinctResults(expected), distinctResults(results))
+		}
+
+		if _, ok := grch38SNPs.Variants[id]; !ok {
 			continue
 		}
 
 		foundInBoth++
 
-		result, err := liftover.Lift(ctx, cf, types.ReferenceGRCh37, snp.chromosome, snp.position)
+		result, err := liftover.Lift(ctx, compact, types.ReferenceGRCh37, types.ReferenceGRCh38, snp.Chromosome, snp.Position)
 		if err != nil {
 			continue
 		}
 
-		if result == grch38SNPs[snp.id].position {
+		if result.Chromosome == grch38SNPs.Variants[id].Chromosome && result.Position == grch38SNPs.Variants[id].Position {
 			successFullyLifted++
 		}
 	}
@@ -82,52 +601,214 @@ func TestChainFile(t *testing.T) {
 	assert.Greater(t, float64(successFullyLifted)/float64(foundInBoth), 0.995)
 }
 
-type snp struct {
-	id         int64
-	chromosome types.Chromosome
-	position   int64
-}
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
 
-func readClinVarSNPs(path string) (map[int64]snp, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
+	data := readChainFileData(t, "../../testdata/GRCh37_to_GRCh38.chain.gz")
 
-	dr, err := compress.Decompress(f)
-	if err != nil {
-		return nil, err
-	}
-	defer dr.Close()
+	cf, err := chainfile.Read(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	cf.From, cf.To = types.ReferenceGRCh37, types.ReferenceGRCh38
+
+	path := filepath.Join(t.TempDir(), "GRCh37_to_GRCh38.idx")
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+
+	require.NoError(t, chainfile.BuildIndex(cf, f))
+	require.NoError(t, f.Close())
 
-	vcfReader, err := vcfgo.NewReader(dr, false)
-	if err != nil {
-		return nil, err
+	idx, err := chainfile.OpenIndex(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, idx.Close())
+	})
+
+	pairs, err := idx.Pairs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []chainfile.Pair{{From: types.ReferenceGRCh37, To: types.ReferenceGRCh38}}, pairs)
+
+	grch37SNPs := readClinVarSNPs(t, "../../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+
+	for _, snp := range grch37SNPs.Variants {
+		expected, expectedErr := liftover.LiftAll(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, snp.Chromosome, snp.Position)
+		results, err := liftover.LiftAll(ctx, idx, types.ReferenceGRCh37, types.ReferenceGRCh38, snp.Chromosome, snp.Position)
+		if expectedErr != nil {
+			require.Error(t, err)
+			assert.Equal(t, liftover.UnmappedReason(expectedErr), liftover.UnmappedReason(err))
+			continue
+		}
+
+		require.NoError(t, err)
+		assert.Equal(t, distinctResults(expected), distinctResults(results))
 	}
 
-	snps := make(map[int64]snp)
-	for {
-		variant := vcfReader.Read()
-		if variant == nil {
-			break
+	t.Run("Invalid", func(t *testing.T) {
+		index, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		truncatedPath := filepath.Join(t.TempDir(), "truncated.idx")
+		require.NoError(t, os.WriteFile(truncatedPath, index[:len(index)/2], 0o644))
+
+		_, err = chainfile.OpenIndex(truncatedPath)
+		require.ErrorIs(t, err, chainfile.ErrInvalidIndex)
+	})
+}
+
+func BenchmarkRead(b *testing.B) {
+	data := readChainFileData(b, "../../testdata/GRCh37_to_GRCh38.chain.gz")
+
+	b.Run("Tree", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := chainfile.Read(bytes.NewReader(data))
+			require.NoError(b, err)
 		}
+	})
 
-		idStr, err := variant.Info().Get("RS")
-		if err != nil {
-			continue
+	b.Run("Compact", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := chainfile.ReadCompact(bytes.NewReader(data))
+			require.NoError(b, err)
 		}
+	})
 
-		id, err := strconv.ParseInt(idStr.(string), 10, 64)
-		if err != nil {
-			continue
+	b.Run("Index", func(b *testing.B) {
+		path := buildIndex(b, data)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			idx, err := chainfile.OpenIndex(path)
+			require.NoError(b, err)
+			require.NoError(b, idx.Close())
+		}
+	})
+}
+
+func BenchmarkLift(b *testing.B) {
+	ctx := context.Background()
+
+	data := readChainFileData(b, "../../testdata/GRCh37_to_GRCh38.chain.gz")
+
+	cf, err := chainfile.Read(bytes.NewReader(data))
+	require.NoError(b, err)
+
+	compact, err := chainfile.ReadCompact(bytes.NewReader(data))
+	require.NoError(b, err)
+
+	idx, err := chainfile.OpenIndex(buildIndex(b, data))
+	require.NoError(b, err)
+	b.Cleanup(func() {
+		require.NoError(b, idx.Close())
+	})
+
+	grch37SNPs := readClinVarSNPs(b, "../../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+
+	var loci []liftover.Locus
+	for _, locus := range grch37SNPs.Variants {
+		loci = append(loci, locus)
+	}
+
+	for _, src := range []struct {
+		name string
+		src  liftover.ChainSource
+	}{
+		{"Tree", cf},
+		{"Compact", compact},
+		{"Index", idx},
+	} {
+		b.Run(src.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				locus := loci[i%len(loci)]
+				_, _ = liftover.Lift(ctx, src.src, types.ReferenceGRCh37, types.ReferenceGRCh38, locus.Chromosome, locus.Position)
+			}
+		})
+	}
+}
+
+// distinctResults sorts the results and removes duplicates. The test chain
+// files contain duplicate chains, which the interval tree drops.
+func distinctResults(results []liftover.LiftResult) []liftover.LiftResult {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ChainID != results[j].ChainID {
+			return results[i].ChainID < results[j].ChainID
 		}
 
-		snps[id] = snp{
-			id:         id,
-			chromosome: names.Chromosome(variant.Chromosome),
-			position:   int64(variant.Pos),
+		return results[i].Position < results[j].Position
+	})
+
+	var distinct []liftover.LiftResult
+	for i, result := range results {
+		if i == 0 || result != results[i-1] {
+			distinct = append(distinct, result)
 		}
 	}
 
-	return snps, nil
+	return distinct
+}
+
+func buildIndex(b *testing.B, data []byte) string {
+	cf, err := chainfile.Read(bytes.NewReader(data))
+	require.NoError(b, err)
+
+	path := filepath.Join(b.TempDir(), "index.idx")
+
+	f, err := os.Create(path)
+	require.NoError(b, err)
+
+	require.NoError(b, chainfile.BuildIndex(cf, f))
+	require.NoError(b, f.Close())
+
+	return path
+}
+
+func readChainFileData(t testing.TB, path string) []byte {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	dr, err := compress.Decompress(f)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, dr.Close())
+	})
+
+	data, err := io.ReadAll(dr)
+	require.NoError(t, err)
+
+	return data
+}
+
+func readChainFile(t *testing.T, path string) *chainfile.ChainFile {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	dr, err := compress.Decompress(f)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, dr.Close())
+	})
+
+	cf, err := chainfile.Read(dr)
+	require.NoError(t, err)
+
+	return cf
+}
+
+func readClinVarSNPs(t testing.TB, path string, reference types.Reference) *evaluate.TruthSet {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	ts, err := evaluate.ReadClinVar(f, reference)
+	require.NoError(t, err)
+
+	return ts
 }
@@ -0,0 +1,273 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/zymatik-com/genobase/types"
+)
+
+// CompactChainFile is a memory efficient, read only, alternative to ChainFile.
+// Rather than building interval trees, chains and their alignment blocks are
+// stored in flat slices sorted by position, and searched with binary search.
+type CompactChainFile struct {
+	// From is the source genome assembly of the chain file. If unset, the chain
+	// file will be used to lift from any assembly.
+	From types.Reference
+	// To is the target genome assembly of the chain file. If unset, the chain
+	// file will be used to lift to any assembly.
+	To types.Reference
+
+	chromosomes map[types.Chromosome]*compactChromosome
+	chains      []compactChain
+	chainByID   map[int64]int
+	blocks      []compactBlock
+}
+
+// compactChromosome indexes the chains of a single reference chromosome.
+type compactChromosome struct {
+	// chains are indices into CompactChainFile.chains, ordered by start.
+	chains []int
+	// maxEnd is the greatest end of any chain up to and including each index,
+	// this bounds how far back overlapping chains can start.
+	maxEnd []int64
+}
+
+type compactChain struct {
+	types.Chain
+	// blocks is the range of CompactChainFile.blocks holding the alignment
+	// blocks of the chain, ordered by reference offset.
+	blocksStart, blocksEnd int
+}
+
+// compactBlock is an alignment block. Offsets are relative to the start of the
+// chain, so they fit in 32 bits for any real chromosome.
+type compactBlock struct {
+	refOffset   int32
+	queryOffset int32
+	size        int32
+}
+
+// ReadCompact loads a chain file from an io.Reader into a CompactChainFile.
+// As with ChainFile, if chain IDs are not unique the last chain with a given ID
+// is used for alignment lookups.
+func ReadCompact(reader io.Reader, opts ...ReadOption) (*CompactChainFile, error) {
+	cf := &CompactChainFile{
+		chromosomes: make(map[types.Chromosome]*compactChromosome),
+		chainByID:   make(map[int64]int),
+	}
+
+	r := NewReader(reader, opts...)
+	for {
+		chain, alignments, err := r.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, err
+		}
+
+		compact := compactChain{
+			Chain:       *chain.toType(),
+			blocksStart: len(cf.blocks),
+		}
+
+		for _, alignment := range alignments {
+			if alignment.RefOffset+alignment.Size > math.MaxInt32 || alignment.QueryOffset+alignment.Size > math.MaxInt32 {
+				return nil, fmt.Errorf("chain %d is too long", chain.ID_)
+			}
+
+			cf.blocks = append(cf.blocks, compactBlock{
+				refOffset:   int32(alignment.RefOffset),
+				queryOffset: int32(alignment.QueryOffset),
+				size:        int32(alignment.Size),
+			})
+		}
+
+		compact.blocksEnd = len(cf.blocks)
+
+		cf.chainByID[chain.ID_] = len(cf.chains)
+		cf.chains = append(cf.chains, compact)
+	}
+
+	for i := range cf.chains {
+		refName := cf.chains[i].RefName
+
+		chromosome, ok := cf.chromosomes[refName]
+		if !ok {
+			chromosome = &compactChromosome{}
+			cf.chromosomes[refName] = chromosome
+		}
+
+		chromosome.chains = append(chromosome.chains, i)
+	}
+
+	for _, chromosome := range cf.chromosomes {
+		sort.SliceStable(chromosome.chains, func(i, j int) bool {
+			return cf.chains[chromosome.chains[i]].RefStart < cf.chains[chromosome.chains[j]].RefStart
+		})
+
+		chromosome.maxEnd = make([]int64, len(chromosome.chains))
+		for i, index := range chromosome.chains {
+			chromosome.maxEnd[i] = cf.chains[index].RefEnd
+			if i > 0 {
+				chromosome.maxEnd[i] = max(chromosome.maxEnd[i], chromosome.maxEnd[i-1])
+			}
+		}
+	}
+
+	return cf, nil
+}
+
+// Pairs returns the source and target genome assemblies of the chain file, or
+// nothing if they have not been set.
+func (cf *CompactChainFile) Pairs(ctx context.Context) ([]Pair, error) {
+	if cf.From == "" || cf.To == "" {
+		return nil, nil
+	}
+
+	return []Pair{{From: cf.From, To: cf.To}}, nil
+}
+
+// GetChain returns the chain for the given chromosome and position. If the
+// position is covered by multiple chains, chains that align the position are
+// preferred over those where it falls in a gap, and then the highest scoring
+// chain is chosen.
+func (cf *CompactChainFile) GetChain(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error) {
+	indices, err := cf.overlapping(from, to, chromosome, position, position)
+	if err != nil {
+		return nil, err
+	}
+
+	best, bestAligned := -1, false
+	for _, index := range indices {
+		aligned := cf.aligns(index, position)
+
+		if best == -1 || (aligned && !bestAligned) ||
+			(aligned == bestAligned && cf.chains[index].Score > cf.chains[best].Score) {
+			best, bestAligned = index, aligned
+		}
+	}
+
+	chain := cf.chains[best].Chain
+	return &chain, nil
+}
+
+// GetChains returns all the chains overlapping the given chromosome region,
+// ordered by their start position.
+func (cf *CompactChainFile) GetChains(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, start, end int64) ([]types.Chain, error) {
+	indices, err := cf.overlapping(from, to, chromosome, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	chains := make([]types.Chain, 0, len(indices))
+	for _, index := range indices {
+		chains = append(chains, cf.chains[index].Chain)
+	}
+
+	return chains, nil
+}
+
+// GetAlignment returns the first alignment block in the given chain that ends
+// at or after the given offset from the start of the chain. If the offset falls
+// in a gap between two blocks, the following block is returned.
+func (cf *CompactChainFile) GetAlignment(ctx context.Context, chainID int64, offset int64) (*types.Alignment, error) {
+	index, ok := cf.chainByID[chainID]
+	if !ok {
+		return nil, fmt.Errorf("chain %d not found", chainID)
+	}
+
+	i := cf.findBlock(index, offset)
+	if i == cf.chains[index].blocksEnd {
+		return nil, fmt.Errorf("offset %d not found in chain %d: %w", offset, chainID, ErrGap)
+	}
+
+	block := cf.blocks[i]
+
+	return &types.Alignment{
+		ChainID:     chainID,
+		RefOffset:   int64(block.refOffset),
+		QueryOffset: int64(block.queryOffset),
+		Size:        int64(block.size),
+	}, nil
+}
+
+// overlapping returns the indices of the chains overlapping the given region
+// (inclusive), ordered by their start position.
+func (cf *CompactChainFile) overlapping(from, to types.Reference, chromosome types.Chromosome, start, end int64) ([]int, error) {
+	if (cf.From != "" && cf.From != from) || (cf.To != "" && cf.To != to) {
+		return nil, fmt.Errorf("chain file does not map from %s to %s: %w", from, to, ErrNoChain)
+	}
+
+	c, ok := cf.chromosomes[chromosome]
+	if !ok {
+		return nil, fmt.Errorf("chromosome %s not found: %w", chromosome, ErrNoChain)
+	}
+
+	// The chains that start at or before the end of the region.
+	n := sort.Search(len(c.chains), func(i int) bool {
+		return cf.chains[c.chains[i]].RefStart > end
+	})
+
+	// Walk backwards until no earlier chain can reach the start of the region.
+	var indices []int
+	for i := n - 1; i >= 0 && c.maxEnd[i] >= start; i-- {
+		if cf.chains[c.chains[i]].RefEnd >= start {
+			indices = append(indices, c.chains[i])
+		}
+	}
+
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("region %d-%d not found in chromosome %s: %w", start, end, chromosome, ErrDeleted)
+	}
+
+	// Restore the order by start position.
+	for i, j := 0, len(indices)-1; i < j; i, j = i+1, j-1 {
+		indices[i], indices[j] = indices[j], indices[i]
+	}
+
+	return indices, nil
+}
+
+// findBlock returns the index of the first block of the chain that ends at or
+// after the given offset, or the end of the chain's blocks if there is none.
+func (cf *CompactChainFile) findBlock(index int, offset int64) int {
+	chain := &cf.chains[index]
+	blocks := cf.blocks[chain.blocksStart:chain.blocksEnd]
+
+	return chain.blocksStart + sort.Search(len(blocks), func(i int) bool {
+		return int64(blocks[i].refOffset)+int64(blocks[i].size) >= offset
+	})
+}
+
+// aligns returns whether the given (1-based) position falls within an
+// alignment block of the chain.
+func (cf *CompactChainFile) aligns(index int, position int64) bool {
+	chain := &cf.chains[index]
+	offset := position - chain.RefStart
+
+	i := cf.findBlock(index, offset)
+	if i == chain.blocksEnd {
+		return false
+	}
+
+	return offset > int64(cf.blocks[i].refOffset)
+}
@@ -0,0 +1,192 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+	"github.com/zymatik-com/genobase/types"
+)
+
+// Compose returns a new chain file that maps directly from the reference genome
+// of a to the query genome of b, where the query genome of a is the reference
+// genome of b (eg. composing NCBI36 to GRCh37 with GRCh37 to GRCh38 produces
+// NCBI36 to GRCh38).
+//
+// Every pair of overlapping chains produces a composed chain, containing the
+// bases aligned by both. As the original alignments are not available, the
+// score of a composed chain is the number of bases it aligns. Chains are given
+// new sequential IDs.
+func Compose(a, b *ChainFile) (*ChainFile, error) {
+	if a.To != "" && b.From != "" && a.To != b.From {
+		return nil, fmt.Errorf("cannot compose chains to %s with chains from %s", a.To, b.From)
+	}
+
+	composed := &ChainFile{
+		From:               a.From,
+		To:                 b.To,
+		ChainsByChromosome: make(map[types.Chromosome]augmentedtree.Tree),
+		ChainByID:          make(map[int64]*Chain),
+	}
+
+	var id int64
+	for _, aChain := range a.SortedChains() {
+		if aChain.RefStrand != "+" {
+			return nil, fmt.Errorf("chain %d has unsupported reference strand %q", aChain.ID_, aChain.RefStrand)
+		}
+
+		blocksByChain := make(map[*Chain][]composedBlock)
+		var bChains []*Chain
+
+		for _, alignment := range aChain.SortedAlignments() {
+			blocks, err := composeAlignment(aChain, alignment, b)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, block := range blocks {
+				if _, ok := blocksByChain[block.bChain]; !ok {
+					bChains = append(bChains, block.bChain)
+				}
+
+				blocksByChain[block.bChain] = append(blocksByChain[block.bChain], block)
+			}
+		}
+
+		for _, bChain := range bChains {
+			id++
+			composed.add(newComposedChain(id, aChain, bChain, blocksByChain[bChain]))
+		}
+	}
+
+	return composed, nil
+}
+
+// composedBlock is an aligned block in the composed chain, using absolute
+// coordinates (the query coordinates are on the strand of the composed chain).
+type composedBlock struct {
+	bChain     *Chain
+	refStart   int64
+	queryStart int64
+	size       int64
+}
+
+// composeAlignment maps a single alignment block of aChain through the chains
+// of b.
+func composeAlignment(aChain *Chain, alignment *Alignment, b *ChainFile) ([]composedBlock, error) {
+	tree, ok := b.ChainsByChromosome[aChain.QueryName]
+	if !ok {
+		return nil, nil
+	}
+
+	refStart := aChain.RefStart + alignment.RefOffset
+
+	// The alignment in the intermediate genome, on the positive strand.
+	midStart := aChain.QueryStart + alignment.QueryOffset
+	if aChain.QueryStrand == "-" {
+		midStart = aChain.QuerySize - (midStart + alignment.Size)
+	}
+	midEnd := midStart + alignment.Size
+
+	var blocks []composedBlock
+	for _, interval := range tree.Query(&Interval{Start: midStart, End: midEnd}) {
+		bChain := interval.(*Chain)
+		if bChain.RefStrand != "+" {
+			return nil, fmt.Errorf("chain %d has unsupported reference strand %q", bChain.ID_, bChain.RefStrand)
+		}
+
+		query := &Interval{Start: midStart - bChain.RefStart, End: midEnd - bChain.RefStart}
+		for _, bInterval := range bChain.Alignments.Query(query) {
+			bAlignment := bInterval.(*Alignment)
+
+			bMidStart := bChain.RefStart + bAlignment.RefOffset
+			overlapStart := max(midStart, bMidStart)
+			overlapEnd := min(midEnd, bMidStart+bAlignment.Size)
+			if overlapEnd <= overlapStart {
+				continue
+			}
+
+			size := overlapEnd - overlapStart
+			// Position in the query genome of b, on the strand of bChain.
+			queryStart := bChain.QueryStart + bAlignment.QueryOffset + (overlapStart - bMidStart)
+
+			block := composedBlock{
+				bChain:     bChain,
+				refStart:   refStart + (overlapStart - midStart),
+				queryStart: queryStart,
+				size:       size,
+			}
+
+			if aChain.QueryStrand == "-" {
+				// The intermediate genome runs backwards relative to the reference,
+				// so the composed chain is on the opposite strand to bChain.
+				block.refStart = refStart + (midEnd - overlapEnd)
+				block.queryStart = bChain.QuerySize - (queryStart + size)
+			}
+
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks, nil
+}
+
+func newComposedChain(id int64, aChain, bChain *Chain, blocks []composedBlock) *Chain {
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].refStart < blocks[j].refStart
+	})
+
+	queryStrand := bChain.QueryStrand
+	if aChain.QueryStrand == "-" {
+		queryStrand = flipStrand(queryStrand)
+	}
+
+	first, last := blocks[0], blocks[len(blocks)-1]
+
+	chain := &Chain{
+		RefName:     aChain.RefName,
+		RefSize:     aChain.RefSize,
+		RefStrand:   "+",
+		RefStart:    first.refStart,
+		RefEnd:      last.refStart + last.size,
+		QueryName:   bChain.QueryName,
+		QuerySize:   bChain.QuerySize,
+		QueryStrand: queryStrand,
+		QueryStart:  first.queryStart,
+		QueryEnd:    last.queryStart + last.size,
+		ID_:         id,
+		Alignments:  augmentedtree.New(1),
+	}
+
+	for _, block := range blocks {
+		chain.Score += block.size
+
+		chain.Alignments.Add(&Alignment{
+			RefOffset:   block.refStart - chain.RefStart,
+			QueryOffset: block.queryStart - chain.QueryStart,
+			Size:        block.size,
+		})
+	}
+
+	return chain
+}
+
+func flipStrand(strand string) string {
+	if strand == "-" {
+		return "+"
+	}
+
+	return "-"
+}
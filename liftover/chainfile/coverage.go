@@ -0,0 +1,198 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+	"github.com/zymatik-com/genobase/types"
+)
+
+// Region is a region of a reference chromosome (0-based, half-open, as in BED).
+type Region struct {
+	Chromosome types.Chromosome
+	Start      int64
+	End        int64
+}
+
+// Coverage summarizes how much of a reference chromosome is covered by chains.
+// Overlapping chains and blocks are only counted once.
+type Coverage struct {
+	Chromosome types.Chromosome // Reference chromosome name.
+	Size       int64            // Size of the reference chromosome.
+	Chains     int              // Number of chains on the chromosome.
+	Aligned    int64            // Bases within an alignment block.
+	Gap        int64            // Bases within a chain, but not within an alignment block.
+	Unmapped   int64            // Bases not within any chain.
+}
+
+// Coverage returns the coverage of each reference chromosome, ordered by name.
+func (cf *ChainFile) Coverage() []Coverage {
+	var report []Coverage
+	for _, chromosome := range cf.sortedChromosomes() {
+		chains := chromosomeChains(cf.ChainsByChromosome[chromosome])
+
+		coverage := Coverage{
+			Chromosome: chromosome,
+			Chains:     len(chains),
+		}
+
+		var spans []Region
+		for _, chain := range chains {
+			coverage.Size = max(coverage.Size, chain.RefSize)
+			spans = append(spans, Region{Chromosome: chromosome, Start: chain.RefStart, End: chain.RefEnd})
+		}
+
+		var spanned int64
+		for _, region := range mergeRegions(spans) {
+			spanned += region.End - region.Start
+		}
+
+		for _, region := range mappedRegions(chromosome, chains) {
+			coverage.Aligned += region.End - region.Start
+		}
+
+		coverage.Gap = spanned - coverage.Aligned
+		coverage.Unmapped = coverage.Size - spanned
+
+		report = append(report, coverage)
+	}
+
+	return report
+}
+
+// MappedRegions returns the regions of the reference genome that are within an
+// alignment block, ordered by chromosome and position.
+func (cf *ChainFile) MappedRegions() []Region {
+	var regions []Region
+	for _, chromosome := range cf.sortedChromosomes() {
+		regions = append(regions, mappedRegions(chromosome, chromosomeChains(cf.ChainsByChromosome[chromosome]))...)
+	}
+
+	return regions
+}
+
+// UnmappedRegions returns the regions of the reference genome that are not
+// within any alignment block (including gaps within chains), ordered by
+// chromosome and position. Only chromosomes with at least one chain are
+// included, as the size of other chromosomes is not known.
+func (cf *ChainFile) UnmappedRegions() []Region {
+	var regions []Region
+	for _, chromosome := range cf.sortedChromosomes() {
+		chains := chromosomeChains(cf.ChainsByChromosome[chromosome])
+
+		var size int64
+		for _, chain := range chains {
+			size = max(size, chain.RefSize)
+		}
+
+		var start int64
+		for _, region := range mappedRegions(chromosome, chains) {
+			if region.Start > start {
+				regions = append(regions, Region{Chromosome: chromosome, Start: start, End: region.Start})
+			}
+
+			start = region.End
+		}
+
+		if start < size {
+			regions = append(regions, Region{Chromosome: chromosome, Start: start, End: size})
+		}
+	}
+
+	return regions
+}
+
+// WriteBED writes the regions to an io.Writer in the BED3 format.
+func WriteBED(w io.Writer, regions []Region) error {
+	bw := bufio.NewWriter(w)
+
+	for _, region := range regions {
+		if _, err := fmt.Fprintf(bw, "%s\t%d\t%d\n", region.Chromosome, region.Start, region.End); err != nil {
+			return fmt.Errorf("failed to write BED file: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write BED file: %w", err)
+	}
+
+	return nil
+}
+
+func (cf *ChainFile) sortedChromosomes() []types.Chromosome {
+	chromosomes := make([]types.Chromosome, 0, len(cf.ChainsByChromosome))
+	for chromosome := range cf.ChainsByChromosome {
+		chromosomes = append(chromosomes, chromosome)
+	}
+
+	sort.Slice(chromosomes, func(i, j int) bool {
+		return chromosomes[i] < chromosomes[j]
+	})
+
+	return chromosomes
+}
+
+func chromosomeChains(tree augmentedtree.Tree) []*Chain {
+	var chains []*Chain
+	tree.Traverse(func(interval augmentedtree.Interval) {
+		chains = append(chains, interval.(*Chain))
+	})
+
+	return chains
+}
+
+// mappedRegions returns the merged alignment blocks of the chains.
+func mappedRegions(chromosome types.Chromosome, chains []*Chain) []Region {
+	var blocks []Region
+	for _, chain := range chains {
+		chain.Alignments.Traverse(func(interval augmentedtree.Interval) {
+			alignment := interval.(*Alignment)
+
+			blocks = append(blocks, Region{
+				Chromosome: chromosome,
+				Start:      chain.RefStart + alignment.RefOffset,
+				End:        chain.RefStart + alignment.RefOffset + alignment.Size,
+			})
+		})
+	}
+
+	return mergeRegions(blocks)
+}
+
+// mergeRegions merges overlapping and adjacent regions (on the same chromosome).
+func mergeRegions(regions []Region) []Region {
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].Start < regions[j].Start
+	})
+
+	var merged []Region
+	for _, region := range regions {
+		if region.End <= region.Start {
+			continue
+		}
+
+		if n := len(merged); n > 0 && region.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, region.End)
+			continue
+		}
+
+		merged = append(merged, region)
+	}
+
+	return merged
+}
@@ -0,0 +1,52 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons a position or region could not be lifted, modelled on the reasons
+// reported in UCSC liftOver's unMapped file. Errors returned by chain sources
+// and the liftover functions wrap one of these, so they can be classified with
+// errors.Is.
+var (
+	// ErrNoChain indicates there are no chains at all for the chromosome.
+	ErrNoChain = errors.New("no chain for chromosome")
+	// ErrDeleted indicates no chain covers the position (or enough of the
+	// region), ie. it has been deleted in the target genome.
+	ErrDeleted = errors.New("deleted in target")
+	// ErrGap indicates the position falls in a gap between two alignment blocks.
+	ErrGap = errors.New("falls in alignment gap")
+	// ErrSplit indicates the region is only covered when multiple chains are
+	// combined.
+	ErrSplit = errors.New("split across chains")
+	// ErrDuplicated indicates the region maps to multiple places in the target
+	// genome.
+	ErrDuplicated = errors.New("duplicated in target")
+)
+
+// ParseError is returned when a chain file is malformed.
+type ParseError struct {
+	Line int   // Line number (1-based) of the chain file.
+	Err  error // The problem with the line.
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
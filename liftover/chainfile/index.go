@@ -0,0 +1,575 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/zymatik-com/genobase/types"
+)
+
+// The index is a little endian binary file with the following layout:
+//
+//	header       indexHeaderSize bytes, see below.
+//	names        length prefixed (uint32) chromosome and assembly names.
+//	chromosomes  per reference chromosome: name, first chain, chain count.
+//	chains       fixed size chain records, grouped by reference chromosome
+//	             and ordered by start position.
+//	ids          chain ID and chain index pairs, ordered by chain ID.
+//	blocks       packed alignment blocks (ref offset, query offset, size).
+//
+// Everything apart from the names is fixed size, so the index can be searched
+// in place, without being decoded.
+
+var indexMagic = [8]byte{'N', 'U', 'C', 'L', 'C', 'H', 'N', '1'}
+
+const (
+	indexHeaderSize     = 80
+	indexChromosomeSize = 16
+	indexChainSize      = 96
+	indexIDSize         = 16
+	indexBlockSize      = 12
+
+	// noName is the name index of an unset assembly.
+	noName = math.MaxUint32
+)
+
+// ErrInvalidIndex is returned when a chain index is malformed.
+var ErrInvalidIndex = errors.New("invalid chain index")
+
+// BuildIndex writes a compact binary index of the chain file to w, that can be
+// opened with OpenIndex. As with ChainFile, if chain IDs are not unique the
+// chain stored in ChainByID is used for alignment lookups.
+func BuildIndex(cf *ChainFile, w io.Writer) error {
+	var names []string
+	nameIndex := make(map[string]uint32)
+	addName := func(name string) uint32 {
+		if i, ok := nameIndex[name]; ok {
+			return i
+		}
+
+		nameIndex[name] = uint32(len(names))
+		names = append(names, name)
+
+		return nameIndex[name]
+	}
+
+	from, to := uint32(noName), uint32(noName)
+	if cf.From != "" {
+		from = addName(string(cf.From))
+	}
+	if cf.To != "" {
+		to = addName(string(cf.To))
+	}
+
+	chains := cf.SortedChains()
+	sort.SliceStable(chains, func(i, j int) bool {
+		if chains[i].RefName != chains[j].RefName {
+			return chains[i].RefName < chains[j].RefName
+		}
+
+		return chains[i].RefStart < chains[j].RefStart
+	})
+
+	var chromosomes []byte
+	var chainRecords []byte
+	var blocks []byte
+	chainIndex := make(map[*Chain]uint32, len(chains))
+
+	var maxEnd int64
+	for i, chain := range chains {
+		if i == 0 || chain.RefName != chains[i-1].RefName {
+			// Count the chains on this chromosome.
+			n := 1
+			for i+n < len(chains) && chains[i+n].RefName == chain.RefName {
+				n++
+			}
+
+			chromosomes = binary.LittleEndian.AppendUint32(chromosomes, addName(string(chain.RefName)))
+			chromosomes = binary.LittleEndian.AppendUint32(chromosomes, uint32(i))
+			chromosomes = binary.LittleEndian.AppendUint32(chromosomes, uint32(n))
+			chromosomes = binary.LittleEndian.AppendUint32(chromosomes, 0)
+
+			maxEnd = chain.RefEnd
+		}
+
+		maxEnd = max(maxEnd, chain.RefEnd)
+		chainIndex[chain] = uint32(i)
+
+		alignments := chain.SortedAlignments()
+
+		record := make([]byte, indexChainSize)
+		binary.LittleEndian.PutUint64(record[0:], uint64(chain.ID_))
+		binary.LittleEndian.PutUint64(record[8:], uint64(chain.Score))
+		binary.LittleEndian.PutUint64(record[16:], uint64(chain.RefSize))
+		binary.LittleEndian.PutUint64(record[24:], uint64(chain.RefStart))
+		binary.LittleEndian.PutUint64(record[32:], uint64(chain.RefEnd))
+		binary.LittleEndian.PutUint64(record[40:], uint64(maxEnd))
+		binary.LittleEndian.PutUint64(record[48:], uint64(chain.QuerySize))
+		binary.LittleEndian.PutUint64(record[56:], uint64(chain.QueryStart))
+		binary.LittleEndian.PutUint64(record[64:], uint64(chain.QueryEnd))
+		binary.LittleEndian.PutUint64(record[72:], uint64(len(blocks)/indexBlockSize))
+		binary.LittleEndian.PutUint32(record[80:], uint32(len(alignments)))
+		binary.LittleEndian.PutUint32(record[84:], addName(string(chain.QueryName)))
+		record[88] = strandByte(chain.RefStrand)
+		record[89] = strandByte(chain.QueryStrand)
+		chainRecords = append(chainRecords, record...)
+
+		for _, alignment := range alignments {
+			if alignment.RefOffset+alignment.Size > math.MaxInt32 || alignment.QueryOffset+alignment.Size > math.MaxInt32 {
+				return fmt.Errorf("chain %d is too long", chain.ID_)
+			}
+
+			blocks = binary.LittleEndian.AppendUint32(blocks, uint32(alignment.RefOffset))
+			blocks = binary.LittleEndian.AppendUint32(blocks, uint32(alignment.QueryOffset))
+			blocks = binary.LittleEndian.AppendUint32(blocks, uint32(alignment.Size))
+		}
+	}
+
+	// The interval trees drop chains with duplicate IDs and start positions, so
+	// the chain in ChainByID may not have been indexed.
+	indexByID := make(map[int64]uint32, len(chains))
+	for _, chain := range chains {
+		indexByID[chain.ID_] = chainIndex[chain]
+	}
+	for id, chain := range cf.ChainByID {
+		if i, ok := chainIndex[chain]; ok {
+			indexByID[id] = i
+		}
+	}
+
+	ids := make([]int64, 0, len(indexByID))
+	for id := range indexByID {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	idRecords := make([]byte, 0, len(ids)*indexIDSize)
+	for _, id := range ids {
+		idRecords = binary.LittleEndian.AppendUint64(idRecords, uint64(id))
+		idRecords = binary.LittleEndian.AppendUint32(idRecords, indexByID[id])
+		idRecords = binary.LittleEndian.AppendUint32(idRecords, 0)
+	}
+
+	var nameRecords []byte
+	for _, name := range names {
+		nameRecords = binary.LittleEndian.AppendUint32(nameRecords, uint32(len(name)))
+		nameRecords = append(nameRecords, name...)
+	}
+
+	header := make([]byte, indexHeaderSize)
+	copy(header, indexMagic[:])
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(names)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(chromosomes)/indexChromosomeSize))
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(chains)))
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(ids)))
+	binary.LittleEndian.PutUint64(header[24:], uint64(len(blocks)/indexBlockSize))
+
+	offset := uint64(indexHeaderSize)
+	for i, section := range [][]byte{nameRecords, chromosomes, chainRecords, idRecords, blocks} {
+		binary.LittleEndian.PutUint64(header[32+8*i:], offset)
+		offset += uint64(len(section))
+	}
+
+	binary.LittleEndian.PutUint32(header[72:], from)
+	binary.LittleEndian.PutUint32(header[76:], to)
+
+	bw := bufio.NewWriter(w)
+	for _, section := range [][]byte{header, nameRecords, chromosomes, chainRecords, idRecords, blocks} {
+		if _, err := bw.Write(section); err != nil {
+			return fmt.Errorf("failed to write chain index: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write chain index: %w", err)
+	}
+
+	return nil
+}
+
+func strandByte(strand string) byte {
+	if strand == "-" {
+		return '-'
+	}
+
+	return '+'
+}
+
+// Index is a chain source backed by a memory mapped chain index (see
+// BuildIndex). It must be closed when no longer needed.
+type Index struct {
+	// From is the source genome assembly of the index.
+	From types.Reference
+	// To is the target genome assembly of the index.
+	To types.Reference
+
+	close func() error
+
+	names       []types.Chromosome
+	chromosomes map[types.Chromosome]indexChromosome
+	chains      []byte
+	ids         []byte
+	blocks      []byte
+}
+
+type indexChromosome struct {
+	first, count int
+}
+
+// indexChain is a decoded chain record.
+type indexChain struct {
+	types.Chain
+	maxEnd                 int64
+	blocksStart, blocksEnd int
+}
+
+// OpenIndex memory maps the chain index at the given path.
+func OpenIndex(path string) (*Index, error) {
+	data, closeFn, err := mapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open chain index: %w", err)
+	}
+
+	idx, err := newIndex(data)
+	if err != nil {
+		_ = closeFn()
+		return nil, err
+	}
+	idx.close = closeFn
+
+	return idx, nil
+}
+
+// Close unmaps the chain index.
+func (idx *Index) Close() error {
+	if idx.close == nil {
+		return nil
+	}
+
+	err := idx.close()
+	idx.close = nil
+
+	return err
+}
+
+func newIndex(data []byte) (*Index, error) {
+	if len(data) < indexHeaderSize || !bytes.Equal(data[:len(indexMagic)], indexMagic[:]) {
+		return nil, fmt.Errorf("bad header: %w", ErrInvalidIndex)
+	}
+
+	nameCount := int(binary.LittleEndian.Uint32(data[8:]))
+	chromosomeCount := int(binary.LittleEndian.Uint32(data[12:]))
+	chainCount := int(binary.LittleEndian.Uint32(data[16:]))
+	idCount := int(binary.LittleEndian.Uint32(data[20:]))
+	blockCount := binary.LittleEndian.Uint64(data[24:])
+
+	var offsets [6]uint64
+	for i := 0; i < 5; i++ {
+		offsets[i] = binary.LittleEndian.Uint64(data[32+8*i:])
+	}
+	offsets[5] = uint64(len(data))
+
+	for i := 0; i < 5; i++ {
+		if offsets[i] < indexHeaderSize || offsets[i] > offsets[i+1] {
+			return nil, fmt.Errorf("bad section offsets: %w", ErrInvalidIndex)
+		}
+	}
+
+	section := func(i int) []byte {
+		return data[offsets[i]:offsets[i+1]]
+	}
+
+	idx := &Index{
+		chromosomes: make(map[types.Chromosome]indexChromosome, chromosomeCount),
+		chains:      section(2),
+		ids:         section(3),
+		blocks:      section(4),
+	}
+
+	if len(section(1)) != chromosomeCount*indexChromosomeSize ||
+		len(idx.chains) != chainCount*indexChainSize ||
+		len(idx.ids) != idCount*indexIDSize ||
+		uint64(len(idx.blocks)) != blockCount*indexBlockSize {
+		return nil, fmt.Errorf("bad section sizes: %w", ErrInvalidIndex)
+	}
+
+	names := section(0)
+	for i := 0; i < nameCount; i++ {
+		if len(names) < 4 {
+			return nil, fmt.Errorf("truncated names: %w", ErrInvalidIndex)
+		}
+
+		n := int(binary.LittleEndian.Uint32(names))
+		if len(names)-4 < n {
+			return nil, fmt.Errorf("truncated names: %w", ErrInvalidIndex)
+		}
+
+		idx.names = append(idx.names, types.Chromosome(names[4:4+n]))
+		names = names[4+n:]
+	}
+
+	name := func(i uint32) (types.Chromosome, error) {
+		if int(i) >= len(idx.names) {
+			return "", fmt.Errorf("bad name %d: %w", i, ErrInvalidIndex)
+		}
+
+		return idx.names[i], nil
+	}
+
+	for _, ref := range []struct {
+		offset int
+		ref    *types.Reference
+	}{{72, &idx.From}, {76, &idx.To}} {
+		if i := binary.LittleEndian.Uint32(data[ref.offset:]); i != noName {
+			n, err := name(i)
+			if err != nil {
+				return nil, err
+			}
+
+			*ref.ref = types.Reference(n)
+		}
+	}
+
+	chromosomes := section(1)
+	for i := 0; i < chromosomeCount; i++ {
+		record := chromosomes[i*indexChromosomeSize:]
+
+		n, err := name(binary.LittleEndian.Uint32(record))
+		if err != nil {
+			return nil, err
+		}
+
+		chromosome := indexChromosome{
+			first: int(binary.LittleEndian.Uint32(record[4:])),
+			count: int(binary.LittleEndian.Uint32(record[8:])),
+		}
+		if chromosome.first+chromosome.count > chainCount {
+			return nil, fmt.Errorf("bad chromosome %s: %w", n, ErrInvalidIndex)
+		}
+
+		idx.chromosomes[n] = chromosome
+	}
+
+	for i := 0; i < chainCount; i++ {
+		chain := idx.chain(i)
+		if chain.blocksEnd < chain.blocksStart || uint64(chain.blocksEnd) > blockCount {
+			return nil, fmt.Errorf("bad chain %d: %w", chain.ID, ErrInvalidIndex)
+		}
+
+		if int(binary.LittleEndian.Uint32(idx.chains[i*indexChainSize+84:])) >= len(idx.names) {
+			return nil, fmt.Errorf("bad chain %d: %w", chain.ID, ErrInvalidIndex)
+		}
+	}
+
+	for i := 0; i < idCount; i++ {
+		if int(binary.LittleEndian.Uint32(idx.ids[i*indexIDSize+8:])) >= chainCount {
+			return nil, fmt.Errorf("bad chain id record: %w", ErrInvalidIndex)
+		}
+	}
+
+	return idx, nil
+}
+
+// chain decodes the chain record at the given index (the reference chromosome
+// name is not stored in the record, so is left empty).
+func (idx *Index) chain(i int) indexChain {
+	record := idx.chains[i*indexChainSize : (i+1)*indexChainSize]
+
+	blocksStart := int(binary.LittleEndian.Uint64(record[72:]))
+
+	chain := indexChain{
+		Chain: types.Chain{
+			ID:          int64(binary.LittleEndian.Uint64(record[0:])),
+			Score:       int64(binary.LittleEndian.Uint64(record[8:])),
+			RefSize:     int64(binary.LittleEndian.Uint64(record[16:])),
+			RefStrand:   string(record[88]),
+			RefStart:    int64(binary.LittleEndian.Uint64(record[24:])),
+			RefEnd:      int64(binary.LittleEndian.Uint64(record[32:])),
+			QuerySize:   int64(binary.LittleEndian.Uint64(record[48:])),
+			QueryStrand: string(record[89]),
+			QueryStart:  int64(binary.LittleEndian.Uint64(record[56:])),
+			QueryEnd:    int64(binary.LittleEndian.Uint64(record[64:])),
+		},
+		maxEnd:      int64(binary.LittleEndian.Uint64(record[40:])),
+		blocksStart: blocksStart,
+		blocksEnd:   blocksStart + int(binary.LittleEndian.Uint32(record[80:])),
+	}
+
+	if name := int(binary.LittleEndian.Uint32(record[84:])); name < len(idx.names) {
+		chain.QueryName = idx.names[name]
+	}
+
+	return chain
+}
+
+func (idx *Index) block(i int) (refOffset, queryOffset, size int64) {
+	record := idx.blocks[i*indexBlockSize:]
+
+	return int64(binary.LittleEndian.Uint32(record)),
+		int64(binary.LittleEndian.Uint32(record[4:])),
+		int64(binary.LittleEndian.Uint32(record[8:]))
+}
+
+// Pairs returns the source and target genome assemblies of the index, or
+// nothing if they have not been set.
+func (idx *Index) Pairs(ctx context.Context) ([]Pair, error) {
+	if idx.From == "" || idx.To == "" {
+		return nil, nil
+	}
+
+	return []Pair{{From: idx.From, To: idx.To}}, nil
+}
+
+// GetChain returns the chain for the given chromosome and position. If the
+// position is covered by multiple chains, chains that align the position are
+// preferred over those where it falls in a gap, and then the highest scoring
+// chain is chosen.
+func (idx *Index) GetChain(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error) {
+	chains, err := idx.overlapping(from, to, chromosome, position, position)
+	if err != nil {
+		return nil, err
+	}
+
+	best, bestAligned := -1, false
+	for i := range chains {
+		aligned := idx.aligns(&chains[i], position)
+
+		if best == -1 || (aligned && !bestAligned) ||
+			(aligned == bestAligned && chains[i].Score > chains[best].Score) {
+			best, bestAligned = i, aligned
+		}
+	}
+
+	return &chains[best].Chain, nil
+}
+
+// GetChains returns all the chains overlapping the given chromosome region,
+// ordered by their start position.
+func (idx *Index) GetChains(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, start, end int64) ([]types.Chain, error) {
+	chains, err := idx.overlapping(from, to, chromosome, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]types.Chain, 0, len(chains))
+	for _, chain := range chains {
+		result = append(result, chain.Chain)
+	}
+
+	return result, nil
+}
+
+// GetAlignment returns the first alignment block in the given chain that ends
+// at or after the given offset from the start of the chain. If the offset falls
+// in a gap between two blocks, the following block is returned.
+func (idx *Index) GetAlignment(ctx context.Context, chainID int64, offset int64) (*types.Alignment, error) {
+	n := len(idx.ids) / indexIDSize
+	i := sort.Search(n, func(i int) bool {
+		return int64(binary.LittleEndian.Uint64(idx.ids[i*indexIDSize:])) >= chainID
+	})
+	if i == n || int64(binary.LittleEndian.Uint64(idx.ids[i*indexIDSize:])) != chainID {
+		return nil, fmt.Errorf("chain %d not found", chainID)
+	}
+
+	chain := idx.chain(int(binary.LittleEndian.Uint32(idx.ids[i*indexIDSize+8:])))
+
+	block := idx.findBlock(&chain, offset)
+	if block == chain.blocksEnd {
+		return nil, fmt.Errorf("offset %d not found in chain %d: %w", offset, chainID, ErrGap)
+	}
+
+	refOffset, queryOffset, size := idx.block(block)
+
+	return &types.Alignment{
+		ChainID:     chainID,
+		RefOffset:   refOffset,
+		QueryOffset: queryOffset,
+		Size:        size,
+	}, nil
+}
+
+// overlapping returns the chains overlapping the given region (inclusive),
+// ordered by their start position.
+func (idx *Index) overlapping(from, to types.Reference, chromosome types.Chromosome, start, end int64) ([]indexChain, error) {
+	if (idx.From != "" && idx.From != from) || (idx.To != "" && idx.To != to) {
+		return nil, fmt.Errorf("chain index does not map from %s to %s: %w", from, to, ErrNoChain)
+	}
+
+	c, ok := idx.chromosomes[chromosome]
+	if !ok {
+		return nil, fmt.Errorf("chromosome %s not found: %w", chromosome, ErrNoChain)
+	}
+
+	field := func(i, offset int) int64 {
+		return int64(binary.LittleEndian.Uint64(idx.chains[i*indexChainSize+offset:]))
+	}
+
+	// The chains that start at or before the end of the region.
+	n := sort.Search(c.count, func(i int) bool {
+		return field(c.first+i, 24) > end
+	})
+
+	// Walk backwards until no earlier chain can reach the start of the region.
+	var chains []indexChain
+	for i := c.first + n - 1; i >= c.first && field(i, 40) >= start; i-- {
+		if field(i, 32) >= start {
+			chain := idx.chain(i)
+			chain.RefName = chromosome
+			chains = append(chains, chain)
+		}
+	}
+
+	if len(chains) == 0 {
+		return nil, fmt.Errorf("region %d-%d not found in chromosome %s: %w", start, end, chromosome, ErrDeleted)
+	}
+
+	// Restore the order by start position.
+	for i, j := 0, len(chains)-1; i < j; i, j = i+1, j-1 {
+		chains[i], chains[j] = chains[j], chains[i]
+	}
+
+	return chains, nil
+}
+
+// findBlock returns the index of the first block of the chain that ends at or
+// after the given offset, or the end of the chain's blocks if there is none.
+func (idx *Index) findBlock(chain *indexChain, offset int64) int {
+	return chain.blocksStart + sort.Search(chain.blocksEnd-chain.blocksStart, func(i int) bool {
+		refOffset, _, size := idx.block(chain.blocksStart + i)
+		return refOffset+size >= offset
+	})
+}
+
+// aligns returns whether the given (1-based) position falls within an
+// alignment block of the chain.
+func (idx *Index) aligns(chain *indexChain, position int64) bool {
+	offset := position - chain.RefStart
+
+	block := idx.findBlock(chain, offset)
+	if block == chain.blocksEnd {
+		return false
+	}
+
+	refOffset, _, _ := idx.block(block)
+
+	return offset > refOffset
+}
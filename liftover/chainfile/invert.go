@@ -0,0 +1,95 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"fmt"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+	"github.com/zymatik-com/genobase/types"
+)
+
+// Invert returns a new chain file that maps from the query genome back to the
+// reference genome (the equivalent of UCSC chainSwap). Chains on the negative
+// strand of the query genome are converted so that their reference coordinates
+// are on the positive strand, as required by the chain format.
+func (cf *ChainFile) Invert() (*ChainFile, error) {
+	inverted := &ChainFile{
+		From:               cf.To,
+		To:                 cf.From,
+		ChainsByChromosome: make(map[types.Chromosome]augmentedtree.Tree),
+		ChainByID:          make(map[int64]*Chain),
+	}
+
+	for _, chain := range cf.SortedChains() {
+		invertedChain, err := chain.invert()
+		if err != nil {
+			return nil, err
+		}
+
+		inverted.add(invertedChain)
+	}
+
+	return inverted, nil
+}
+
+func (c *Chain) invert() (*Chain, error) {
+	if c.RefStrand != "+" {
+		return nil, fmt.Errorf("chain %d has unsupported reference strand %q", c.ID_, c.RefStrand)
+	}
+
+	inverted := &Chain{
+		Score:       c.Score,
+		RefName:     c.QueryName,
+		RefSize:     c.QuerySize,
+		RefStrand:   "+",
+		RefStart:    c.QueryStart,
+		RefEnd:      c.QueryEnd,
+		QueryName:   c.RefName,
+		QuerySize:   c.RefSize,
+		QueryStrand: c.QueryStrand,
+		QueryStart:  c.RefStart,
+		QueryEnd:    c.RefEnd,
+		ID_:         c.ID_,
+		Alignments:  augmentedtree.New(1),
+	}
+
+	negative := c.QueryStrand == "-"
+	if negative {
+		// Flip both ranges onto the opposite strand, so the new reference range is
+		// on the positive strand.
+		inverted.RefStart, inverted.RefEnd = c.QuerySize-c.QueryEnd, c.QuerySize-c.QueryStart
+		inverted.QueryStart, inverted.QueryEnd = c.RefSize-c.RefEnd, c.RefSize-c.RefStart
+	}
+
+	c.Alignments.Traverse(func(interval augmentedtree.Interval) {
+		alignment := interval.(*Alignment)
+
+		refStart := c.RefStart + alignment.RefOffset
+		queryStart := c.QueryStart + alignment.QueryOffset
+
+		newRefStart, newQueryStart := queryStart, refStart
+		if negative {
+			newRefStart = c.QuerySize - (queryStart + alignment.Size)
+			newQueryStart = c.RefSize - (refStart + alignment.Size)
+		}
+
+		inverted.Alignments.Add(&Alignment{
+			RefOffset:   newRefStart - inverted.RefStart,
+			QueryOffset: newQueryStart - inverted.QueryStart,
+			Size:        alignment.Size,
+		})
+	})
+
+	return inverted, nil
+}
@@ -0,0 +1,161 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"sort"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+	"github.com/zymatik-com/genobase/types"
+)
+
+// Net returns a new chain file in which every reference base is aligned by at
+// most one chain (in the spirit of UCSC chainNet and netChainSubset, as used to
+// produce the UCSC over.chain files). Chains are processed in order of
+// descending score, and each keeps only the parts of its alignment blocks that
+// have not already been claimed by a higher scoring chain. Lower scoring chains
+// may therefore fill the gaps of higher scoring chains. Chains left without any
+// alignment blocks are dropped. Chains keep their IDs, unless the ID has already
+// been used by another chain (eg. when chain files have been concatenated), in
+// which case they are given a new, unused, ID.
+func (cf *ChainFile) Net() *ChainFile {
+	netted := &ChainFile{
+		From:               cf.From,
+		To:                 cf.To,
+		ChainsByChromosome: make(map[types.Chromosome]augmentedtree.Tree),
+		ChainByID:          make(map[int64]*Chain),
+	}
+
+	var maxID int64
+	for _, chain := range cf.SortedChains() {
+		maxID = max(maxID, chain.ID_)
+	}
+
+	for _, chromosome := range cf.sortedChromosomes() {
+		chains := chromosomeChains(cf.ChainsByChromosome[chromosome])
+
+		sort.SliceStable(chains, func(i, j int) bool {
+			if chains[i].Score != chains[j].Score {
+				return chains[i].Score > chains[j].Score
+			}
+
+			if chains[i].ID_ != chains[j].ID_ {
+				return chains[i].ID_ < chains[j].ID_
+			}
+
+			return chains[i].RefStart < chains[j].RefStart
+		})
+
+		// The reference regions (relative to the start of the chromosome) already
+		// claimed, which never overlap one another.
+		claimed := augmentedtree.New(1)
+
+		for _, chain := range chains {
+			var kept []Alignment
+			for _, alignment := range chain.SortedAlignments() {
+				kept = append(kept, unclaimed(claimed, chain.RefStart, alignment)...)
+			}
+
+			if len(kept) == 0 {
+				continue
+			}
+
+			sub := subChain(chain, kept)
+			if _, exists := netted.ChainByID[sub.ID_]; exists {
+				maxID++
+				sub.ID_ = maxID
+			}
+
+			netted.add(sub)
+
+			for _, alignment := range kept {
+				start := chain.RefStart + alignment.RefOffset
+				claimed.Add(&Interval{Start: start, End: start + alignment.Size})
+			}
+		}
+	}
+
+	return netted
+}
+
+// unclaimed returns the parts of the alignment block that do not overlap the
+// claimed regions.
+func unclaimed(claimed augmentedtree.Tree, chainStart int64, alignment *Alignment) []Alignment {
+	blockStart := chainStart + alignment.RefOffset
+	start, end := blockStart, blockStart+alignment.Size
+
+	// The claimed regions overlapping the block, in order. Tree queries include
+	// the ends of regions, so regions that only touch the block are skipped.
+	var overlapping []*Interval
+	for _, interval := range claimed.Query(&Interval{Start: start, End: end}) {
+		if region := interval.(*Interval); region.End > start && region.Start < end {
+			overlapping = append(overlapping, region)
+		}
+	}
+
+	sort.Slice(overlapping, func(i, j int) bool {
+		return overlapping[i].Start < overlapping[j].Start
+	})
+
+	var pieces []Alignment
+	piece := func(pieceStart, pieceEnd int64) {
+		if pieceEnd > pieceStart {
+			pieces = append(pieces, Alignment{
+				RefOffset:   pieceStart - chainStart,
+				QueryOffset: alignment.QueryOffset + (pieceStart - blockStart),
+				Size:        pieceEnd - pieceStart,
+			})
+		}
+	}
+
+	for _, region := range overlapping {
+		piece(start, region.Start)
+		start = max(start, region.End)
+	}
+
+	piece(start, end)
+
+	return pieces
+}
+
+// subChain returns a copy of the chain containing only the given alignment
+// blocks (with offsets relative to the start of the original chain).
+func subChain(chain *Chain, alignments []Alignment) *Chain {
+	first, last := alignments[0], alignments[len(alignments)-1]
+
+	sub := &Chain{
+		Score:       chain.Score,
+		RefName:     chain.RefName,
+		RefSize:     chain.RefSize,
+		RefStrand:   chain.RefStrand,
+		RefStart:    chain.RefStart + first.RefOffset,
+		RefEnd:      chain.RefStart + last.RefOffset + last.Size,
+		QueryName:   chain.QueryName,
+		QuerySize:   chain.QuerySize,
+		QueryStrand: chain.QueryStrand,
+		QueryStart:  chain.QueryStart + first.QueryOffset,
+		QueryEnd:    chain.QueryStart + last.QueryOffset + last.Size,
+		ID_:         chain.ID_,
+		Alignments:  augmentedtree.New(1),
+	}
+
+	for _, alignment := range alignments {
+		sub.Alignments.Add(&Alignment{
+			RefOffset:   alignment.RefOffset - first.RefOffset,
+			QueryOffset: alignment.QueryOffset - first.QueryOffset,
+			Size:        alignment.Size,
+		})
+	}
+
+	return sub
+}
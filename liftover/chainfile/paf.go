@@ -0,0 +1,205 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+// ReadPAF converts the alignments in a PAF file (eg. from minimap2) into a
+// chain file, with one chain per alignment. The target sequence of each
+// alignment becomes the reference of the chain (so the chain file lifts from
+// the target assembly to the query assembly), and the alignment blocks are
+// taken from the CIGAR string in the cg:Z tag (minimap2 -c). Chains are scored
+// using the AS:i tag if present, otherwise by the number of matching bases.
+func ReadPAF(r io.Reader) (*ChainFile, error) {
+	cf := &ChainFile{
+		ChainsByChromosome: make(map[types.Chromosome]augmentedtree.Tree),
+		ChainByID:          make(map[int64]*Chain),
+	}
+
+	var lineNumber int
+	var id int64
+
+	scanner := bufio.NewScanner(r)
+	// CIGAR strings of long alignments can be very large.
+	scanner.Buffer(nil, 1<<30)
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id++
+		chain, err := parsePAF(line, id)
+		if err != nil {
+			return nil, &ParseError{Line: lineNumber, Err: err}
+		}
+
+		cf.add(chain)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read PAF file: %w", err)
+	}
+
+	return cf, nil
+}
+
+func parsePAF(line string, id int64) (*Chain, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 12 {
+		return nil, fmt.Errorf("invalid PAF line: expected at least 12 columns, got %d", len(fields))
+	}
+
+	var values [12]int64
+	for _, i := range []int{1, 2, 3, 6, 7, 8, 9} {
+		value, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PAF column %d %q", i+1, fields[i])
+		}
+
+		values[i] = value
+	}
+
+	queryName, querySize, queryStart, queryEnd := fields[0], values[1], values[2], values[3]
+	strand := fields[4]
+	targetName, targetSize, targetStart, targetEnd := fields[5], values[6], values[7], values[8]
+	score := values[9]
+
+	if strand != "+" && strand != "-" {
+		return nil, fmt.Errorf("invalid strand %q", strand)
+	}
+
+	var cigar string
+	for _, tag := range fields[12:] {
+		if value, ok := strings.CutPrefix(tag, "cg:Z:"); ok {
+			cigar = value
+		} else if value, ok := strings.CutPrefix(tag, "AS:i:"); ok {
+			as, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AS tag %q", tag)
+			}
+
+			score = as
+		}
+	}
+
+	if cigar == "" {
+		return nil, errors.New("missing cg:Z tag (alignments must include a CIGAR string)")
+	}
+
+	chain := &Chain{
+		Score:       score,
+		RefName:     names.Chromosome(targetName),
+		RefSize:     targetSize,
+		RefStrand:   "+",
+		RefStart:    targetStart,
+		RefEnd:      targetEnd,
+		QueryName:   names.Chromosome(queryName),
+		QuerySize:   querySize,
+		QueryStrand: strand,
+		QueryStart:  queryStart,
+		QueryEnd:    queryEnd,
+		ID_:         id,
+		Alignments:  augmentedtree.New(1),
+	}
+
+	if strand == "-" {
+		// The CIGAR string aligns the target to the reverse complement of the
+		// query, as do chains.
+		chain.QueryStart, chain.QueryEnd = querySize-queryEnd, querySize-queryStart
+	}
+
+	var alignments []Alignment
+	var refOffset, queryOffset int64
+	// Whether the previous operation was an aligned block.
+	var aligned bool
+	for len(cigar) > 0 {
+		i := strings.IndexAny(cigar, "MIDNSHP=X")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid CIGAR string %q", cigar)
+		}
+
+		length, err := strconv.ParseInt(cigar[:i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIGAR operation %q", cigar[:i+1])
+		}
+
+		op := cigar[i]
+		cigar = cigar[i+1:]
+
+		switch op {
+		case 'M', '=', 'X':
+			// Adjacent matches and mismatches form a single block.
+			if !aligned {
+				alignments = append(alignments, Alignment{RefOffset: refOffset, QueryOffset: queryOffset})
+			}
+
+			alignments[len(alignments)-1].Size += length
+			refOffset += length
+			queryOffset += length
+			aligned = true
+			continue
+		case 'D', 'N':
+			refOffset += length
+		case 'I':
+			queryOffset += length
+		}
+
+		// Clipping and padding do not consume either sequence, but still separate
+		// blocks.
+		aligned = false
+	}
+
+	if err := addAlignments(chain, alignments); err != nil {
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// addAlignments adds the alignment blocks to a converted chain, checking they
+// span the same region as the chain.
+func addAlignments(chain *Chain, alignments []Alignment) error {
+	if len(alignments) == 0 {
+		return errors.New("alignment has no aligned blocks")
+	}
+
+	last := alignments[len(alignments)-1]
+	if refSpan := last.RefOffset + last.Size; refSpan != chain.RefEnd-chain.RefStart {
+		return fmt.Errorf("alignment blocks span %d target bases, but the alignment spans %d", refSpan, chain.RefEnd-chain.RefStart)
+	}
+
+	if querySpan := last.QueryOffset + last.Size; querySpan != chain.QueryEnd-chain.QueryStart {
+		return fmt.Errorf("alignment blocks span %d query bases, but the alignment spans %d", querySpan, chain.QueryEnd-chain.QueryStart)
+	}
+
+	for i := range alignments {
+		chain.Alignments.Add(&alignments[i])
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+// ReadPSL converts the alignments in a PSL file (eg. from BLAT) into a chain
+// file, with one chain per alignment (the equivalent of UCSC pslToChain). The
+// target sequence of each alignment becomes the reference of the chain (so the
+// chain file lifts from the target assembly to the query assembly). Chains are
+// scored using the UCSC PSL score. Any psLayout header is skipped.
+func ReadPSL(r io.Reader) (*ChainFile, error) {
+	cf := &ChainFile{
+		ChainsByChromosome: make(map[types.Chromosome]augmentedtree.Tree),
+		ChainByID:          make(map[int64]*Chain),
+	}
+
+	var lineNumber int
+	var id int64
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<30)
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Skip the header (which doesn't start with the number of matches).
+		fields := strings.Fields(line)
+		if _, err := strconv.ParseInt(fields[0], 10, 64); err != nil && id == 0 {
+			continue
+		}
+
+		id++
+		chain, err := parsePSL(fields, id)
+		if err != nil {
+			return nil, &ParseError{Line: lineNumber, Err: err}
+		}
+
+		cf.add(chain)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read PSL file: %w", err)
+	}
+
+	return cf, nil
+}
+
+func parsePSL(fields []string, id int64) (*Chain, error) {
+	if len(fields) != 21 {
+		return nil, fmt.Errorf("invalid PSL line: expected 21 columns, got %d", len(fields))
+	}
+
+	var values [21]int64
+	for i, field := range fields {
+		if i == 8 || i == 9 || i == 13 || i >= 18 {
+			// Strand, names and block lists.
+			continue
+		}
+
+		value, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PSL column %d %q", i+1, field)
+		}
+
+		values[i] = value
+	}
+
+	matches, misMatches, repMatches := values[0], values[1], values[2]
+	queryNumInsert, targetNumInsert := values[4], values[6]
+	strand := fields[8]
+	queryName, querySize, queryStart, queryEnd := fields[9], values[10], values[11], values[12]
+	targetName, targetSize, targetStart, targetEnd := fields[13], values[14], values[15], values[16]
+	blockCount := values[17]
+
+	if strand != "+" && strand != "-" && strand != "++" && strand != "-+" {
+		// A second strand of '-' (translated alignments) would put the target on
+		// the negative strand, which chains do not support.
+		return nil, fmt.Errorf("unsupported strand %q", strand)
+	}
+	strand = strand[:1]
+
+	blockSizes, err := parsePSLList(fields[18], blockCount)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block sizes: %w", err)
+	}
+
+	queryStarts, err := parsePSLList(fields[19], blockCount)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query starts: %w", err)
+	}
+
+	targetStarts, err := parsePSLList(fields[20], blockCount)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target starts: %w", err)
+	}
+
+	chain := &Chain{
+		// Repeat matches only count half, as in the UCSC pslScore.
+		Score:       matches + repMatches/2 - misMatches - queryNumInsert - targetNumInsert,
+		RefName:     names.Chromosome(targetName),
+		RefSize:     targetSize,
+		RefStrand:   "+",
+		RefStart:    targetStart,
+		RefEnd:      targetEnd,
+		QueryName:   names.Chromosome(queryName),
+		QuerySize:   querySize,
+		QueryStrand: strand,
+		QueryStart:  queryStart,
+		QueryEnd:    queryEnd,
+		ID_:         id,
+		Alignments:  augmentedtree.New(1),
+	}
+
+	if strand == "-" {
+		// Query starts are on the reverse complemented query, as in chains.
+		chain.QueryStart, chain.QueryEnd = querySize-queryEnd, querySize-queryStart
+	}
+
+	alignments := make([]Alignment, 0, blockCount)
+	for i := range blockSizes {
+		alignment := Alignment{
+			RefOffset:   targetStarts[i] - chain.RefStart,
+			QueryOffset: queryStarts[i] - chain.QueryStart,
+			Size:        blockSizes[i],
+		}
+
+		if alignment.RefOffset < 0 || alignment.QueryOffset < 0 ||
+			(i > 0 && (alignment.RefOffset < alignments[i-1].RefOffset+alignments[i-1].Size ||
+				alignment.QueryOffset < alignments[i-1].QueryOffset+alignments[i-1].Size)) {
+			return nil, fmt.Errorf("block %d is out of order", i+1)
+		}
+
+		alignments = append(alignments, alignment)
+	}
+
+	if err := addAlignments(chain, alignments); err != nil {
+		return nil, err
+	}
+
+	return chain, nil
+}
+
+// parsePSLList parses a comma separated list of integers (with an optional
+// trailing comma).
+func parsePSLList(field string, count int64) ([]int64, error) {
+	parts := strings.Split(strings.TrimSuffix(field, ","), ",")
+	if int64(len(parts)) != count {
+		return nil, fmt.Errorf("expected %d values, got %d", count, len(parts))
+	}
+
+	values := make([]int64, 0, len(parts))
+	for _, part := range parts {
+		value, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q", part)
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,417 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+type readOptions struct {
+	chromosomes map[types.Chromosome]bool
+	strict      bool
+	// collect records errors and skips the affected chains, rather than
+	// stopping at the first error (used by Validate).
+	collect bool
+}
+
+// ReadOption configures the behavior of Read, ReadCompact and NewReader.
+type ReadOption func(*readOptions)
+
+// WithChromosomes only keeps chains for the given reference chromosomes, the
+// alignment blocks of other chains are skipped without being parsed.
+func WithChromosomes(chromosomes ...types.Chromosome) ReadOption {
+	return func(opts *readOptions) {
+		if opts.chromosomes == nil {
+			opts.chromosomes = make(map[types.Chromosome]bool)
+		}
+
+		for _, chromosome := range chromosomes {
+			opts.chromosomes[names.Chromosome(string(chromosome))] = true
+		}
+	}
+}
+
+// WithStrict rejects malformed chains (see Validate), rather than reading
+// unparseable numbers as -1 and accepting inconsistent chains.
+func WithStrict() ReadOption {
+	return func(opts *readOptions) {
+		opts.strict = true
+	}
+}
+
+// Validate checks that a chain file is well formed, reporting every problem
+// found (as a ParseError) for malformed headers, invalid strands, negative
+// sizes, alignment blocks that disagree with the header, and duplicate chain
+// IDs.
+func Validate(r io.Reader) error {
+	reader := NewReader(r, WithStrict())
+	reader.options.collect = true
+
+	for {
+		if _, _, err := reader.next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			reader.errs = append(reader.errs, err)
+			break
+		}
+	}
+
+	return errors.Join(reader.errs...)
+}
+
+// Reader reads chains from a chain file one at a time.
+type Reader struct {
+	scanner *bufio.Scanner
+	options readOptions
+	// line is the number of the last line read.
+	line int
+	// header is the fields of the next chain line, if it has already been read.
+	header     []string
+	headerLine int
+	// idLines is the line each chain ID was first seen on (in strict mode).
+	idLines map[int64]int
+	errs    []error
+	err     error
+}
+
+// NewReader creates a new streaming chain file reader.
+func NewReader(r io.Reader, opts ...ReadOption) *Reader {
+	var options readOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &Reader{
+		scanner: bufio.NewScanner(r),
+		options: options,
+		idLines: make(map[int64]int),
+	}
+}
+
+// Next returns the next chain in the chain file, including its alignment
+// blocks. It returns io.EOF when there are no more chains.
+func (r *Reader) Next() (*Chain, error) {
+	chain, alignments, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+
+	chain.Alignments = augmentedtree.New(1)
+	for i := range alignments {
+		chain.Alignments.Add(&alignments[i])
+	}
+
+	return chain, nil
+}
+
+// next returns the next chain (without its alignments tree) and its alignment
+// blocks, ordered by offset.
+func (r *Reader) next() (*Chain, []Alignment, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+
+	for {
+		header, err := r.nextHeader()
+		if err != nil {
+			r.err = err
+			return nil, nil, err
+		}
+
+		headerLine := r.headerLine
+
+		chain, errs := r.parseHeader(header, headerLine)
+		keep := len(errs) == 0 &&
+			(r.options.chromosomes == nil || r.options.chromosomes[chain.RefName])
+
+		alignments, alignmentErrs := r.readAlignments(keep, headerLine)
+		errs = append(errs, alignmentErrs...)
+
+		if keep && len(errs) == 0 && r.options.strict {
+			errs = checkChain(chain, alignments, headerLine)
+		}
+
+		if len(errs) > 0 {
+			if r.options.collect {
+				r.errs = append(r.errs, errs...)
+				continue
+			}
+
+			r.err = errs[0]
+			return nil, nil, r.err
+		}
+
+		if keep {
+			return chain, alignments, nil
+		}
+	}
+}
+
+// nextHeader returns the fields of the next chain line.
+func (r *Reader) nextHeader() ([]string, error) {
+	if r.header != nil {
+		header := r.header
+		r.header = nil
+		return header, nil
+	}
+
+	for r.scanner.Scan() {
+		r.line++
+
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "chain") {
+			r.headerLine = r.line
+			return strings.Fields(line), nil
+		}
+
+		if r.options.strict {
+			return nil, &ParseError{Line: r.line, Err: errors.New("alignment block outside of a chain")}
+		}
+
+		// Alignment lines before the first chain are ignored.
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// parseHeader parses the fields of a chain line.
+func (r *Reader) parseHeader(header []string, headerLine int) (*Chain, []error) {
+	if len(header) < 13 || (r.options.strict && (len(header) != 13 || header[0] != "chain")) {
+		return nil, []error{&ParseError{Line: headerLine, Err: fmt.Errorf("invalid chain line: %s", strings.Join(header, " "))}}
+	}
+
+	var errs []error
+	parse := func(field, name string) int64 {
+		value, err := r.parseInt(field, name)
+		if err != nil {
+			errs = append(errs, &ParseError{Line: headerLine, Err: err})
+		}
+
+		return value
+	}
+
+	chain := &Chain{
+		Score:       parse(header[1], "score"),
+		RefName:     names.Chromosome(header[2]),
+		RefSize:     parse(header[3], "reference size"),
+		RefStrand:   header[4],
+		RefStart:    parse(header[5], "reference start"),
+		RefEnd:      parse(header[6], "reference end"),
+		QueryName:   names.Chromosome(header[7]),
+		QuerySize:   parse(header[8], "query size"),
+		QueryStrand: header[9],
+		QueryStart:  parse(header[10], "query start"),
+		QueryEnd:    parse(header[11], "query end"),
+		ID_:         parse(header[12], "chain ID"),
+	}
+
+	if len(errs) > 0 || !r.options.strict {
+		return chain, errs
+	}
+
+	fail := func(format string, args ...any) {
+		errs = append(errs, &ParseError{Line: headerLine, Err: fmt.Errorf(format, args...)})
+	}
+
+	for _, strand := range []string{chain.RefStrand, chain.QueryStrand} {
+		if strand != "+" && strand != "-" {
+			fail("invalid strand %q", strand)
+		}
+	}
+
+	if chain.RefStart < 0 || chain.RefStart > chain.RefEnd || chain.RefEnd > chain.RefSize {
+		fail("invalid reference range %d-%d (size %d)", chain.RefStart, chain.RefEnd, chain.RefSize)
+	}
+
+	if chain.QueryStart < 0 || chain.QueryStart > chain.QueryEnd || chain.QueryEnd > chain.QuerySize {
+		fail("invalid query range %d-%d (size %d)", chain.QueryStart, chain.QueryEnd, chain.QuerySize)
+	}
+
+	if line, ok := r.idLines[chain.ID_]; ok {
+		fail("duplicate chain ID %d (first seen on line %d)", chain.ID_, line)
+	} else {
+		r.idLines[chain.ID_] = headerLine
+	}
+
+	return chain, errs
+}
+
+// readAlignments reads the alignment blocks of the current chain, up to the
+// next chain line (or the end of the file). If parse is false, the alignment
+// blocks are skipped.
+func (r *Reader) readAlignments(parse bool, headerLine int) ([]Alignment, []error) {
+	var alignments []Alignment
+	var refOffset, queryOffset int64
+	var errs []error
+	// lastLine is the line of the last alignment block of the chain.
+	var lastLine int
+	// finalLine is the line of the final block (without gaps) of the chain.
+	var finalLine int
+
+	for r.scanner.Scan() {
+		r.line++
+
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "chain") {
+			r.header = strings.Fields(line)
+			r.headerLine = r.line
+			break
+		}
+
+		if !parse {
+			continue
+		}
+
+		lastLine = r.line
+
+		// Skip the rest of the chain after an error.
+		fail := func(err error) {
+			errs = append(errs, &ParseError{Line: r.line, Err: err})
+			parse = false
+		}
+
+		if r.options.strict && finalLine != 0 {
+			fail(fmt.Errorf("alignment block after the final block on line %d", finalLine))
+			continue
+		}
+
+		// Parse an alignment block or the non-aligning region
+		fields := strings.Fields(line)
+		if len(fields) != 1 && len(fields) != 3 {
+			fail(fmt.Errorf("invalid alignment line: %q", line))
+			continue
+		}
+
+		var values [3]int64
+		for i, field := range fields {
+			value, err := r.parseInt(field, []string{"alignment size", "reference gap", "query gap"}[i])
+			if err != nil {
+				fail(err)
+				break
+			}
+
+			values[i] = value
+		}
+
+		if !parse {
+			continue
+		}
+
+		// The size of the block, and the gaps between this and the next block in
+		// the reference and query genomes.
+		size, refGap, queryGap := values[0], values[1], values[2]
+
+		alignments = append(alignments, Alignment{
+			RefOffset:   refOffset,
+			QueryOffset: queryOffset,
+			Size:        size,
+		})
+
+		refOffset += size + refGap
+		queryOffset += size + queryGap
+
+		if len(fields) == 1 {
+			finalLine = r.line
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, append(errs, err)
+	}
+
+	if parse && r.options.strict && finalLine == 0 {
+		errs = append(errs, &ParseError{Line: max(lastLine, headerLine), Err: errors.New("chain does not end with a final alignment block")})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return alignments, nil
+}
+
+// checkChain checks the alignment blocks of a chain agree with its header.
+func checkChain(chain *Chain, alignments []Alignment, headerLine int) []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, &ParseError{Line: headerLine, Err: fmt.Errorf(format, args...)})
+	}
+
+	for _, alignment := range alignments {
+		if alignment.Size < 0 {
+			fail("negative alignment size %d", alignment.Size)
+			return errs
+		}
+	}
+
+	for i := 0; i+1 < len(alignments); i++ {
+		refGap := alignments[i+1].RefOffset - (alignments[i].RefOffset + alignments[i].Size)
+		queryGap := alignments[i+1].QueryOffset - (alignments[i].QueryOffset + alignments[i].Size)
+		if refGap < 0 || queryGap < 0 {
+			fail("negative gap after alignment block %d", i+1)
+			return errs
+		}
+	}
+
+	if len(alignments) > 0 {
+		last := alignments[len(alignments)-1]
+
+		if refSpan := last.RefOffset + last.Size; refSpan != chain.RefEnd-chain.RefStart {
+			fail("alignment blocks span %d reference bases, but the header spans %d", refSpan, chain.RefEnd-chain.RefStart)
+		}
+
+		if querySpan := last.QueryOffset + last.Size; querySpan != chain.QueryEnd-chain.QueryStart {
+			fail("alignment blocks span %d query bases, but the header spans %d", querySpan, chain.QueryEnd-chain.QueryStart)
+		}
+	}
+
+	return errs
+}
+
+// parseInt parses an integer field. Outside of strict mode, unparseable fields
+// are read as -1.
+func (r *Reader) parseInt(field, name string) (int64, error) {
+	value, err := strconv.ParseInt(field, 10, 64)
+	if err != nil {
+		if r.options.strict {
+			return -1, fmt.Errorf("invalid %s %q", name, field)
+		}
+
+		return -1, nil
+	}
+
+	return value, nil
+}
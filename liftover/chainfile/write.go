@@ -0,0 +1,104 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package chainfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/Workiva/go-datastructures/augmentedtree"
+)
+
+// Write writes the chain file to an io.Writer in the UCSC chain format.
+// Chains are written in order of their ID.
+func Write(w io.Writer, cf *ChainFile) error {
+	bw := bufio.NewWriter(w)
+
+	for _, chain := range cf.SortedChains() {
+		if err := writeChain(bw, chain); err != nil {
+			return fmt.Errorf("failed to write chain file: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write chain file: %w", err)
+	}
+
+	return nil
+}
+
+// SortedChains returns all the chains in the chain file, ordered by ID.
+func (cf *ChainFile) SortedChains() []*Chain {
+	var chains []*Chain
+	for _, tree := range cf.ChainsByChromosome {
+		tree.Traverse(func(interval augmentedtree.Interval) {
+			chains = append(chains, interval.(*Chain))
+		})
+	}
+
+	// Chain IDs are not guaranteed to be unique (eg. when chain files have been
+	// concatenated), so fall back to the position of the chain.
+	sort.Slice(chains, func(i, j int) bool {
+		if chains[i].ID_ != chains[j].ID_ {
+			return chains[i].ID_ < chains[j].ID_
+		}
+
+		if chains[i].RefName != chains[j].RefName {
+			return chains[i].RefName < chains[j].RefName
+		}
+
+		if chains[i].RefStart != chains[j].RefStart {
+			return chains[i].RefStart < chains[j].RefStart
+		}
+
+		return chains[i].QueryStart < chains[j].QueryStart
+	})
+
+	return chains
+}
+
+func writeChain(w *bufio.Writer, chain *Chain) error {
+	_, err := fmt.Fprintf(w, "chain %d %s %d %s %d %d %s %d %s %d %d %d\n",
+		chain.Score,
+		chain.RefName, chain.RefSize, chain.RefStrand, chain.RefStart, chain.RefEnd,
+		chain.QueryName, chain.QuerySize, chain.QueryStrand, chain.QueryStart, chain.QueryEnd,
+		chain.ID_)
+	if err != nil {
+		return err
+	}
+
+	alignments := chain.SortedAlignments()
+	for i, alignment := range alignments {
+		if i == len(alignments)-1 {
+			if _, err := fmt.Fprintf(w, "%d\n", alignment.Size); err != nil {
+				return err
+			}
+
+			break
+		}
+
+		next := alignments[i+1]
+
+		refGap := next.RefOffset - (alignment.RefOffset + alignment.Size)
+		queryGap := next.QueryOffset - (alignment.QueryOffset + alignment.Size)
+
+		if _, err := fmt.Fprintf(w, "%d %d %d\n", alignment.Size, refGap, queryGap); err != nil {
+			return err
+		}
+	}
+
+	_, err = w.WriteString("\n")
+	return err
+}
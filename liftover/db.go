@@ -0,0 +1,316 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/zymatik-com/genobase"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/liftover/chainfile"
+)
+
+// The genobase schema has no column for the target assembly of a chain, so
+// chains are stored under a reference that encodes both the source and target
+// assemblies (see pairKey), until genobase gains one.
+//
+// genobase can not delete chains or store them in a transaction either, so the
+// chains of each chromosome are stored under a new generation of the pair (see
+// chainKey), and committed by a record stored under the pair itself, once they
+// have all been stored. Chains left behind by an interrupted attempt belong to
+// a generation that is never committed, and so are never read.
+
+// pairKey identifies the chains of a (from, to) pair.
+func pairKey(from, to types.Reference) types.Reference {
+	return from + "_to_" + to
+}
+
+// chainKey identifies a generation of the chains of a (from, to) pair.
+func chainKey(from, to types.Reference, generation int64) types.Reference {
+	return pairKey(from, to) + types.Reference("/"+strconv.FormatInt(generation, 10))
+}
+
+// commitRecord returns the record that commits a generation of the chains of a
+// chromosome, along with the digest of the chains (see chainsDigest).
+func commitRecord(from, to types.Reference, chromosome types.Chromosome, generation, digest int64) *types.Chain {
+	return &types.Chain{
+		Score:   generation,
+		Ref:     pairKey(from, to),
+		RefName: chromosome,
+		RefSize: digest,
+	}
+}
+
+// getCommitRecord returns the record that committed the chains of a chromosome.
+func getCommitRecord(ctx context.Context, db *genobase.DB, from, to types.Reference, chromosome types.Chromosome) (generation, digest int64, err error) {
+	record, err := db.GetChain(ctx, pairKey(from, to), chromosome, 0)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	return record.Score, record.RefSize, nil
+}
+
+// DBSource is a ChainSource backed by a genobase database. A single database
+// can hold chains for multiple (from, to) pairs side by side.
+type DBSource struct {
+	db    *genobase.DB
+	pairs []Hop
+
+	mu sync.Mutex
+	// generations are the committed generations of each chromosome, which never
+	// change once committed.
+	generations map[chainRegion]int64
+}
+
+// NewDBSource creates a new chain source backed by the given database, which
+// holds chains for the given (from, to) pairs (see StoreChainFile). genobase
+// can not list the pairs it holds chains for, so without them the source will
+// be used for whatever pair it is asked to lift.
+func NewDBSource(db *genobase.DB, pairs ...Hop) *DBSource {
+	return &DBSource{
+		db:          db,
+		pairs:       pairs,
+		generations: make(map[chainRegion]int64),
+	}
+}
+
+// Pairs returns the (from, to) pairs of reference genome assemblies that the
+// source was created with.
+func (s *DBSource) Pairs(ctx context.Context) ([]Hop, error) {
+	return slices.Clone(s.pairs), nil
+}
+
+// GetChain returns the chain for the given chromosome and position.
+func (s *DBSource) GetChain(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error) {
+	generation, err := s.generation(ctx, from, to, chromosome)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := s.db.GetChain(ctx, chainKey(from, to, generation), chromosome, position)
+	if err != nil {
+		return nil, err
+	}
+
+	chain.Ref = from
+
+	return chain, nil
+}
+
+// GetAlignment returns the first alignment block in the given chain that ends
+// at or after the given offset from the start of the chain.
+func (s *DBSource) GetAlignment(ctx context.Context, chainID int64, offset int64) (*types.Alignment, error) {
+	return s.db.GetAlignment(ctx, chainID, offset)
+}
+
+// generation returns the committed generation of the chains of a chromosome,
+// looking it up the first time it is needed.
+func (s *DBSource) generation(ctx context.Context, from, to types.Reference, chromosome types.Chromosome) (int64, error) {
+	region := chainRegion{from: from, to: to, chromosome: chromosome}
+
+	s.mu.Lock()
+	generation, ok := s.generations[region]
+	s.mu.Unlock()
+
+	if ok {
+		return generation, nil
+	}
+
+	generation, _, err := getCommitRecord(ctx, s.db, from, to, chromosome)
+	if err != nil {
+		return -1, err
+	}
+
+	s.mu.Lock()
+	s.generations[region] = generation
+	s.mu.Unlock()
+
+	return generation, nil
+}
+
+// ErrChainsDiffer is returned when storing the chains of a chromosome that
+// already has different chains stored, as they can not be replaced.
+var ErrChainsDiffer = errors.New("different chains already stored")
+
+type storeOptions struct {
+	progress func(stored, total int)
+}
+
+// StoreOption configures the behavior of StoreChainFile.
+type StoreOption func(*storeOptions)
+
+// WithProgress sets a function that is called with the number of chains
+// stored so far (including any skipped as already stored), and the total
+// number of chains in the chain file.
+func WithProgress(progress func(stored, total int)) StoreOption {
+	return func(opts *storeOptions) {
+		opts.progress = progress
+	}
+}
+
+// StoreChainFile stores the chain file in a genobase database (see DBSource)
+// in a queryable format, for lifting from one reference genome assembly to
+// another.
+//
+// Chains are stored one chromosome at a time, each of which is only read once
+// all its chains have been stored. Chromosomes that already hold the same
+// chains are skipped, so storing a chain file again is a no-op, and an
+// interrupted attempt can be resumed by storing the chain file again. Stored
+// chains can not be replaced, so storing different chains for a chromosome
+// returns ErrChainsDiffer (use a new database instead), and the chains of any
+// chromosomes missing from the chain file are kept.
+func StoreChainFile(ctx context.Context, db *genobase.DB, from, to types.Reference, cf *chainfile.ChainFile, opts ...StoreOption) error {
+	var options storeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	chainsByChromosome := make(map[types.Chromosome][]*chainfile.Chain)
+	var chromosomes []types.Chromosome
+	var total int
+	for _, chain := range cf.SortedChains() {
+		if _, ok := chainsByChromosome[chain.RefName]; !ok {
+			chromosomes = append(chromosomes, chain.RefName)
+		}
+
+		chainsByChromosome[chain.RefName] = append(chainsByChromosome[chain.RefName], chain)
+		total++
+	}
+
+	sort.Slice(chromosomes, func(i, j int) bool {
+		return chromosomes[i] < chromosomes[j]
+	})
+
+	var stored int
+	reportProgress := func() {
+		if options.progress != nil {
+			options.progress(stored, total)
+		}
+	}
+
+	for _, chromosome := range chromosomes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chains := chainsByChromosome[chromosome]
+		digest := chainsDigest(chains)
+
+		_, storedDigest, err := getCommitRecord(ctx, db, from, to, chromosome)
+		if err == nil {
+			if storedDigest != digest {
+				return fmt.Errorf("could not store chromosome %s: %w", chromosome, ErrChainsDiffer)
+			}
+
+			stored += len(chains)
+			reportProgress()
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not get chain: %w", err)
+		}
+
+		// Allocate a new generation, so any chains left by an interrupted attempt
+		// are not mixed in with those we store. The allocation is stored before
+		// the start of the chromosome, so it is never read as a commit record.
+		generation, err := db.StoreChain(ctx, pairKey(from, to), &types.Chain{
+			Ref:      pairKey(from, to),
+			RefName:  chromosome,
+			RefStart: -1,
+			RefEnd:   -1,
+		})
+		if err != nil {
+			return fmt.Errorf("could not store chain: %w", err)
+		}
+
+		ref := chainKey(from, to, generation)
+		for _, chain := range chains {
+			if err := storeChain(ctx, db, ref, chain); err != nil {
+				return err
+			}
+
+			stored++
+			reportProgress()
+		}
+
+		_, err = db.StoreChain(ctx, pairKey(from, to), commitRecord(from, to, chromosome, generation, digest))
+		if err != nil {
+			return fmt.Errorf("could not store chain: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// storeChain stores a chain and its alignment blocks (in a single batch).
+func storeChain(ctx context.Context, db *genobase.DB, ref types.Reference, chain *chainfile.Chain) error {
+	chainID, err := db.StoreChain(ctx, ref, &types.Chain{
+		Score:       chain.Score,
+		Ref:         ref,
+		RefName:     chain.RefName,
+		RefSize:     chain.RefSize,
+		RefStrand:   chain.RefStrand,
+		RefStart:    chain.RefStart,
+		RefEnd:      chain.RefEnd,
+		QueryName:   chain.QueryName,
+		QuerySize:   chain.QuerySize,
+		QueryStrand: chain.QueryStrand,
+		QueryStart:  chain.QueryStart,
+		QueryEnd:    chain.QueryEnd,
+	})
+	if err != nil {
+		return fmt.Errorf("could not store chain: %w", err)
+	}
+
+	alignments := chain.SortedAlignments()
+
+	dbAlignments := make([]types.Alignment, 0, len(alignments))
+	for _, alignment := range alignments {
+		dbAlignments = append(dbAlignments, types.Alignment{
+			RefOffset:   alignment.RefOffset,
+			QueryOffset: alignment.QueryOffset,
+			Size:        alignment.Size,
+		})
+	}
+
+	if err := db.StoreAlignments(ctx, chainID, dbAlignments); err != nil {
+		return fmt.Errorf("could not store alignments: %w", err)
+	}
+
+	return nil
+}
+
+// chainsDigest returns a digest of the chains (and their alignment blocks) of
+// a chromosome, to tell whether the same chains are already stored.
+func chainsDigest(chains []*chainfile.Chain) int64 {
+	h := fnv.New64a()
+	for _, chain := range chains {
+		fmt.Fprintf(h, "chain %d %s %d %s %d %d %s %d %s %d %d %d\n", chain.Score,
+			chain.RefName, chain.RefSize, chain.RefStrand, chain.RefStart, chain.RefEnd,
+			chain.QueryName, chain.QuerySize, chain.QueryStrand, chain.QueryStart, chain.QueryEnd, chain.ID_)
+
+		for _, alignment := range chain.SortedAlignments() {
+			fmt.Fprintf(h, "%d %d %d\n", alignment.RefOffset, alignment.QueryOffset, alignment.Size)
+		}
+	}
+
+	return int64(h.Sum64())
+}
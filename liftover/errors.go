@@ -0,0 +1,55 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zymatik-com/nucleo/liftover/chainfile"
+)
+
+// Reasons a position or region could not be lifted, see the chainfile package
+// for details.
+var (
+	ErrNoChain    = chainfile.ErrNoChain
+	ErrDeleted    = chainfile.ErrDeleted
+	ErrGap        = chainfile.ErrGap
+	ErrSplit      = chainfile.ErrSplit
+	ErrDuplicated = chainfile.ErrDuplicated
+)
+
+var unmappedReasons = []error{ErrNoChain, ErrDeleted, ErrGap, ErrSplit, ErrDuplicated}
+
+// UnmappedReason returns the reason a position or region could not be lifted,
+// or nil if the error is not an unmapped error (eg. a database failure).
+func UnmappedReason(err error) error {
+	for _, reason := range unmappedReasons {
+		if errors.Is(err, reason) {
+			return reason
+		}
+	}
+
+	return nil
+}
+
+// withUnmappedReason attaches the given reason to not found errors returned by
+// chain sources that do not use the unmapped errors (eg. a DBSource).
+func withUnmappedReason(err error, reason error) error {
+	if UnmappedReason(err) != nil || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", reason, err)
+}
@@ -0,0 +1,213 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+// Package evaluate benchmarks the accuracy of liftover using paired truth sets,
+// the known positions of the same variants in two reference genome assemblies
+// (eg. ClinVar VCFs for GRCh37 and GRCh38), so that chain files and liftover
+// strategies can be compared reproducibly.
+package evaluate
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/liftover"
+)
+
+// Counts summarizes the liftover of a set of paired variants.
+type Counts struct {
+	Total      int // Number of variants found in both truth sets.
+	Concordant int // Variants lifted to their position in the target truth set.
+	Discordant int // Variants lifted to a different position.
+	Unmapped   int // Variants that could not be lifted.
+}
+
+// Concordance returns the fraction of variants lifted to their position in the
+// target truth set.
+func (c *Counts) Concordance() float64 {
+	if c.Total == 0 {
+		return 0
+	}
+
+	return float64(c.Concordant) / float64(c.Total)
+}
+
+// Discordance is a variant lifted to a different position than its position
+// in the target truth set.
+type Discordance struct {
+	ID       string         // Variant ID.
+	Source   liftover.Locus // Position in the source truth set.
+	Expected liftover.Locus // Position in the target truth set.
+	Lifted   liftover.Locus // Position the variant was lifted to.
+}
+
+// Report is the result of evaluating liftover against paired truth sets.
+type Report struct {
+	From types.Reference // Source reference genome assembly.
+	To   types.Reference // Target reference genome assembly.
+	Counts
+	// UnmappedReasons is the number of variants that could not be lifted, by
+	// reason (eg. "deleted in target").
+	UnmappedReasons map[string]int
+	// ByChromosome breaks down the counts by source chromosome.
+	ByChromosome map[types.Chromosome]*Counts
+	// Discordances are the discordant variants, ordered by ID.
+	Discordances []Discordance
+}
+
+// Evaluate lifts every variant found in both truth sets from the source
+// assembly to the target assembly, and compares the lifted positions with the
+// positions in the target truth set. Variants are lifted as a batch (see
+// liftover.LiftBatch), using the given options. An error is only returned if
+// the chain source fails, variants that can not be lifted are counted as
+// unmapped.
+func Evaluate(ctx context.Context, src liftover.ChainSource, source, target *TruthSet, opts ...liftover.LiftOption) (*Report, error) {
+	var ids []string
+	for id := range source.Variants {
+		if _, ok := target.Variants[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+
+	// Sorted, so the results (and the order of the discordances) are
+	// reproducible.
+	sort.Strings(ids)
+
+	loci := make([]liftover.Locus, len(ids))
+	for i, id := range ids {
+		loci[i] = source.Variants[id]
+	}
+
+	results, err := liftover.LiftBatch(ctx, src, source.Reference, target.Reference, loci, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not lift variants: %w", err)
+	}
+
+	report := &Report{
+		From:            source.Reference,
+		To:              target.Reference,
+		UnmappedReasons: make(map[string]int),
+		ByChromosome:    make(map[types.Chromosome]*Counts),
+	}
+
+	for i, id := range ids {
+		chromosome := loci[i].Chromosome
+
+		counts, ok := report.ByChromosome[chromosome]
+		if !ok {
+			counts = &Counts{}
+			report.ByChromosome[chromosome] = counts
+		}
+
+		report.Total++
+		counts.Total++
+
+		if results[i].Err != nil {
+			report.Unmapped++
+			counts.Unmapped++
+
+			reason := results[i].Err.Error()
+			if unmapped := liftover.UnmappedReason(results[i].Err); unmapped != nil {
+				reason = unmapped.Error()
+			}
+			report.UnmappedReasons[reason]++
+
+			continue
+		}
+
+		expected := target.Variants[id]
+		lifted := liftover.Locus{Chromosome: results[i].Result.Chromosome, Position: results[i].Result.Position}
+
+		if lifted == expected {
+			report.Concordant++
+			counts.Concordant++
+			continue
+		}
+
+		report.Discordant++
+		counts.Discordant++
+
+		report.Discordances = append(report.Discordances, Discordance{
+			ID:       id,
+			Source:   loci[i],
+			Expected: expected,
+			Lifted:   lifted,
+		})
+	}
+
+	return report, nil
+}
+
+// Write writes the per chromosome counts of the report as a tab separated
+// table, followed by the totals.
+func (r *Report) Write(w io.Writer) error {
+	chromosomes := make([]types.Chromosome, 0, len(r.ByChromosome))
+	for chromosome := range r.ByChromosome {
+		chromosomes = append(chromosomes, chromosome)
+	}
+
+	// In the usual order (1-22, X, Y, MT), followed by any other contigs.
+	order := func(chromosome types.Chromosome) int {
+		if n := chromosome.Int(); n > 0 {
+			return n
+		}
+
+		return math.MaxInt
+	}
+
+	sort.Slice(chromosomes, func(i, j int) bool {
+		a, b := order(chromosomes[i]), order(chromosomes[j])
+		if a != b {
+			return a < b
+		}
+
+		return chromosomes[i] < chromosomes[j]
+	})
+
+	bw := bufio.NewWriter(w)
+
+	writeRow := func(name string, counts *Counts) error {
+		_, err := bw.WriteString(name + "\t" +
+			strconv.Itoa(counts.Total) + "\t" +
+			strconv.Itoa(counts.Concordant) + "\t" +
+			strconv.Itoa(counts.Discordant) + "\t" +
+			strconv.Itoa(counts.Unmapped) + "\t" +
+			strconv.FormatFloat(counts.Concordance(), 'f', 6, 64) + "\n")
+		return err
+	}
+
+	if _, err := bw.WriteString("chromosome\ttotal\tconcordant\tdiscordant\tunmapped\tconcordance\n"); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+
+	for _, chromosome := range chromosomes {
+		if err := writeRow(string(chromosome), r.ByChromosome[chromosome]); err != nil {
+			return fmt.Errorf("could not write report: %w", err)
+		}
+	}
+
+	if err := writeRow("total", &r.Counts); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+
+	return nil
+}
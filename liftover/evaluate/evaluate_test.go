@@ -0,0 +1,125 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package evaluate_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/liftover"
+	"github.com/zymatik-com/nucleo/liftover/chainfile"
+	"github.com/zymatik-com/nucleo/liftover/evaluate"
+)
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 500 2 1000 + 100 600 1
+500
+`))
+	require.NoError(t, err)
+
+	source, err := evaluate.ReadDbSNP(strings.NewReader(`585	chr1	9	10	rs1
+585	chr1	19	20	rs2
+585	chr1	699	700	rs3
+585	chr1	29	30	rs4
+585	chr1	39	40	rs5
+585	chr1	49	50	ss6
+`), types.ReferenceGRCh37)
+	require.NoError(t, err)
+
+	assert.Len(t, source.Variants, 5)
+
+	target := evaluate.NewTruthSet(types.ReferenceGRCh38)
+	target.Variants["rs1"] = liftover.Locus{Chromosome: "2", Position: 110}
+	target.Variants["rs2"] = liftover.Locus{Chromosome: "2", Position: 121}
+	target.Variants["rs3"] = liftover.Locus{Chromosome: "2", Position: 800}
+	target.Variants["rs4"] = liftover.Locus{Chromosome: "2", Position: 130}
+	target.Variants["rs7"] = liftover.Locus{Chromosome: "2", Position: 170}
+
+	report, err := evaluate.Evaluate(ctx, cf, source, target)
+	require.NoError(t, err)
+
+	_, err = liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 700)
+	require.Error(t, err)
+
+	assert.Equal(t, &evaluate.Report{
+		From: types.ReferenceGRCh37,
+		To:   types.ReferenceGRCh38,
+		Counts: evaluate.Counts{
+			Total:      4,
+			Concordant: 2,
+			Discordant: 1,
+			Unmapped:   1,
+		},
+		UnmappedReasons: map[string]int{
+			liftover.UnmappedReason(err).Error(): 1,
+		},
+		ByChromosome: map[types.Chromosome]*evaluate.Counts{
+			"1": {Total: 4, Concordant: 2, Discordant: 1, Unmapped: 1},
+		},
+		Discordances: []evaluate.Discordance{
+			{
+				ID:       "rs2",
+				Source:   liftover.Locus{Chromosome: "1", Position: 20},
+				Expected: liftover.Locus{Chromosome: "2", Position: 121},
+				Lifted:   liftover.Locus{Chromosome: "2", Position: 120},
+			},
+		},
+	}, report)
+
+	assert.Equal(t, 0.5, report.Concordance())
+
+	var sb strings.Builder
+	require.NoError(t, report.Write(&sb))
+
+	assert.Equal(t, `chromosome	total	concordant	discordant	unmapped	concordance
+1	4	2	1	1	0.500000
+total	4	2	1	1	0.500000
+`, sb.String())
+}
+
+func TestReadClinVar(t *testing.T) {
+	f, err := os.Open("../../testdata/clinvar_GRCh37_20231230.vcf.gz")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	ts, err := evaluate.ReadClinVar(f, types.ReferenceGRCh37)
+	require.NoError(t, err)
+
+	assert.Equal(t, types.ReferenceGRCh37, ts.Reference)
+	assert.Greater(t, len(ts.Variants), 1000)
+
+	for id, locus := range ts.Variants {
+		assert.True(t, strings.HasPrefix(id, "rs"), id)
+		assert.NotEmpty(t, locus.Chromosome, id)
+		assert.Greater(t, locus.Position, int64(0), id)
+	}
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := evaluate.ReadClinVar(strings.NewReader(`##fileformat=VCFv4.1
+##INFO=<ID=RS,Number=1,Type=String,Description="dbSNP ID">
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO
+1	100	1	A	G	.	.	RS=1
+1	1O1	2	A	G	.	.	RS=2
+`), types.ReferenceGRCh37)
+		require.Error(t, err)
+	})
+}
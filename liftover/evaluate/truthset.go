@@ -0,0 +1,148 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package evaluate
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/brentp/vcfgo"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/compress"
+	"github.com/zymatik-com/nucleo/liftover"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+const (
+	clinVarRSInfoKey = "RS"
+	rsIDPrefix       = "rs"
+)
+
+// TruthSet is the known positions of a set of variants in a reference genome
+// assembly.
+type TruthSet struct {
+	Reference types.Reference // Reference genome assembly of the positions.
+	// Variants maps variant IDs (eg. "rs80357906") to their positions.
+	Variants map[string]liftover.Locus
+}
+
+// NewTruthSet creates a new, empty, truth set.
+func NewTruthSet(reference types.Reference) *TruthSet {
+	return &TruthSet{
+		Reference: reference,
+		Variants:  make(map[string]liftover.Locus),
+	}
+}
+
+// ReadClinVar reads a truth set from a ClinVar VCF (optionally compressed),
+// keyed by the dbSNP ID (the RS INFO field) of each variant. Variants without
+// a dbSNP ID are skipped, but an error is returned for any malformed record,
+// so that it does not silently skew the evaluation.
+func ReadClinVar(r io.Reader, reference types.Reference) (*TruthSet, error) {
+	dr, err := compress.Decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress clinvar vcf: %w", err)
+	}
+	defer dr.Close()
+
+	vcfReader, err := vcfgo.NewReader(dr, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not read clinvar vcf: %w", err)
+	}
+
+	ts := NewTruthSet(reference)
+	for {
+		variant := vcfReader.Read()
+		if variant == nil {
+			break
+		}
+
+		if err := vcfReader.Error(); err != nil {
+			return nil, fmt.Errorf("could not read clinvar vcf: %w", err)
+		}
+
+		value, err := variant.Info().Get(clinVarRSInfoKey)
+		if err != nil {
+			continue
+		}
+
+		id, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			continue
+		}
+
+		ts.Variants[rsIDPrefix+id] = liftover.Locus{
+			Chromosome: names.Chromosome(variant.Chromosome),
+			Position:   int64(variant.Pos),
+		}
+	}
+
+	return ts, nil
+}
+
+// ReadDbSNP reads a truth set from a UCSC dbSNP table (eg. snp130.txt.gz,
+// optionally compressed), keyed by the dbSNP ID of each variant. The table is
+// tab separated, with the chromosome, 0-based start position and name in the
+// second, third and fifth columns. Rows without a dbSNP ID are skipped.
+func ReadDbSNP(r io.Reader, reference types.Reference) (*TruthSet, error) {
+	dr, err := compress.Decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress dbsnp table: %w", err)
+	}
+	defer dr.Close()
+
+	tsvReader := csv.NewReader(dr)
+	tsvReader.Comma = '\t'
+	tsvReader.FieldsPerRecord = -1
+	tsvReader.LazyQuotes = true
+
+	ts := NewTruthSet(reference)
+	for {
+		record, err := tsvReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return nil, fmt.Errorf("could not read dbsnp table: %w", err)
+		}
+
+		if len(record) < 5 || !strings.HasPrefix(record[4], rsIDPrefix) {
+			continue
+		}
+
+		if _, err := strconv.ParseInt(strings.TrimPrefix(record[4], rsIDPrefix), 10, 64); err != nil {
+			continue
+		}
+
+		position, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		ts.Variants[record[4]] = liftover.Locus{
+			Chromosome: names.Chromosome(record[1]),
+			// The position is 0-based in the table.
+			Position: position + 1,
+		}
+	}
+
+	return ts, nil
+}
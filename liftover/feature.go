@@ -0,0 +1,182 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/compress"
+)
+
+// Reasons a BED or GFF feature could not be lifted (besides the unmapped
+// reasons, eg. ErrDeleted), recorded in the rejects file.
+const (
+	// RejectInvalidFeature indicates the feature could not be parsed.
+	RejectInvalidFeature = "invalid feature"
+	// RejectRelatedFeatureRejected indicates another feature in the same GFF
+	// hierarchy (eg. the parent gene, or another exon of the transcript) could
+	// not be lifted.
+	RejectRelatedFeatureRejected = "related feature rejected"
+)
+
+// FeatureStats summarizes the result of lifting a BED or GFF file.
+type FeatureStats struct {
+	Lifted   int            // Number of features lifted to the target genome.
+	Rejected map[string]int // Number of features rejected, by reason.
+}
+
+type featureOptions struct {
+	intervalOptions []IntervalOption
+	compression     string
+}
+
+// FeatureOption configures the behavior of LiftBED and LiftGFF.
+type FeatureOption func(*featureOptions)
+
+// WithIntervalOptions configures how each feature interval is lifted (see
+// LiftInterval). Features are lifted using the highest scoring target segment.
+func WithIntervalOptions(intervalOpts ...IntervalOption) FeatureOption {
+	return func(opts *featureOptions) {
+		opts.intervalOptions = append(opts.intervalOptions, intervalOpts...)
+	}
+}
+
+// WithCompression compresses the lifted and rejected features in the format
+// implied by the given file name (eg. "features.bed.gz"), see compress.Compress.
+func WithCompression(name string) FeatureOption {
+	return func(opts *featureOptions) {
+		opts.compression = name
+	}
+}
+
+// featureWriter writes lifted features, and rejected features preceded by a
+// comment line with the reason they were rejected (as in the UCSC liftOver
+// unMapped file).
+type featureWriter struct {
+	w, rejects   io.WriteCloser
+	bw, brejects *bufio.Writer
+	stats        *FeatureStats
+}
+
+func newFeatureWriter(w, rejects io.Writer, compression string) (*featureWriter, error) {
+	cw, err := compress.Compress(compression, w)
+	if err != nil {
+		return nil, fmt.Errorf("could not compress lifted features: %w", err)
+	}
+
+	crejects, err := compress.Compress(compression, rejects)
+	if err != nil {
+		return nil, fmt.Errorf("could not compress rejected features: %w", err)
+	}
+
+	return &featureWriter{
+		w:        cw,
+		rejects:  crejects,
+		bw:       bufio.NewWriter(cw),
+		brejects: bufio.NewWriter(crejects),
+		stats: &FeatureStats{
+			Rejected: make(map[string]int),
+		},
+	}, nil
+}
+
+// header writes a header or comment line to the lifted and rejected features.
+func (fw *featureWriter) header(line string) error {
+	if _, err := fw.bw.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("could not write header: %w", err)
+	}
+
+	if _, err := fw.brejects.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("could not write header: %w", err)
+	}
+
+	return nil
+}
+
+func (fw *featureWriter) lifted(line string) error {
+	fw.stats.Lifted++
+
+	if _, err := fw.bw.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("could not write lifted feature: %w", err)
+	}
+
+	return nil
+}
+
+func (fw *featureWriter) rejected(line, reason string) error {
+	fw.stats.Rejected[reason]++
+
+	if _, err := fw.brejects.WriteString("#" + reason + "\n" + line + "\n"); err != nil {
+		return fmt.Errorf("could not write rejected feature: %w", err)
+	}
+
+	return nil
+}
+
+func (fw *featureWriter) Close() error {
+	if err := fw.bw.Flush(); err != nil {
+		return fmt.Errorf("could not write lifted features: %w", err)
+	}
+
+	if err := fw.w.Close(); err != nil {
+		return fmt.Errorf("could not write lifted features: %w", err)
+	}
+
+	if err := fw.brejects.Flush(); err != nil {
+		return fmt.Errorf("could not write rejected features: %w", err)
+	}
+
+	if err := fw.rejects.Close(); err != nil {
+		return fmt.Errorf("could not write rejected features: %w", err)
+	}
+
+	return nil
+}
+
+// liftFeatureInterval lifts a feature interval (1-based, inclusive), returning
+// the highest scoring target segment, or the reason it could not be lifted.
+func liftFeatureInterval(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, start, end int64, opts []IntervalOption) (*IntervalSegment, string, error) {
+	if start > end {
+		return nil, RejectInvalidFeature, nil
+	}
+
+	result, err := LiftInterval(ctx, src, from, to, chromosome, start, end, opts...)
+	if err != nil {
+		if reason := UnmappedReason(err); reason != nil {
+			return nil, reason.Error(), nil
+		}
+
+		return nil, "", err
+	}
+
+	return &result.Segments[0], "", nil
+}
+
+// liftFeaturePosition lifts a single feature position (1-based), returning the
+// lifted position, or the reason it could not be lifted.
+func liftFeaturePosition(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, position int64) (*LiftResult, string, error) {
+	result, err := Lift(ctx, src, from, to, chromosome, position)
+	if err != nil {
+		if reason := UnmappedReason(err); reason != nil {
+			return nil, reason.Error(), nil
+		}
+
+		return nil, "", err
+	}
+
+	return result, "", nil
+}
@@ -0,0 +1,355 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/compress"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+const (
+	gffFASTADirective          = "##FASTA"
+	gffSequenceRegionDirective = "##sequence-region"
+	gffResolutionDirective     = "###"
+)
+
+// gffFeature is a feature line of a GFF3 or GTF file.
+type gffFeature struct {
+	line   string
+	fields []string
+	// start and end are the (1-based, inclusive) coordinates of the feature.
+	start, end int64
+	// ids are the IDs of the feature (GFF3 ID, or GTF gene_id).
+	ids []string
+	// parents are the IDs of the parents of the feature (GFF3 Parent, or GTF
+	// gene_id).
+	parents []string
+}
+
+// gffGroup is a hierarchy of related features (eg. a gene and its transcripts
+// and exons), which are lifted or rejected together.
+type gffGroup struct {
+	features []gffFeature
+	ids      map[string]bool
+	// parent is where the parent of the group was lifted to, if it was lifted
+	// earlier in the file (as part of another group).
+	parent *gffPlacement
+}
+
+// gffPlacement is the chromosome and strand a feature was lifted to.
+type gffPlacement struct {
+	chromosome types.Chromosome
+	strand     string
+}
+
+// gffIDs are the IDs of the features that have been lifted or rejected, so
+// that features whose parents were seen earlier in the file (not adjacent to
+// them) can be lifted consistently.
+type gffIDs struct {
+	lifted   map[string]gffPlacement
+	rejected map[string]bool
+}
+
+func newGFFIDs() *gffIDs {
+	return &gffIDs{
+		lifted:   make(map[string]gffPlacement),
+		rejected: make(map[string]bool),
+	}
+}
+
+// LiftGFF lifts every feature in the GFF3 or GTF file read from r (optionally
+// compressed) and writes the lifted features to w, and any features that could
+// not be lifted to rejects (each preceded by a comment with the reason).
+//
+// Related features (linked by the GFF3 ID and Parent attributes, or sharing a
+// GTF gene_id) are lifted together, and are all rejected if any of them can not
+// be lifted, or they are not all lifted to the same chromosome and strand, so
+// that parent/child relationships remain intact. Related features are usually
+// adjacent (eg. a gene followed by its transcripts and exons), but a feature
+// whose parent appeared earlier in the file is also rejected if its parent was
+// rejected, or if it is not lifted to the same chromosome and strand as its
+// parent. Features are remembered until the next "###" directive, which marks
+// that they will not be referred to again. Sequence region directives and any
+// embedded FASTA sequences are dropped, as they describe the source genome.
+func LiftGFF(ctx context.Context, src ChainSource, from, to types.Reference, r io.Reader, w, rejects io.Writer, opts ...FeatureOption) (*FeatureStats, error) {
+	var options featureOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	dr, err := compress.Decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress gff: %w", err)
+	}
+	defer dr.Close()
+
+	fw, err := newFeatureWriter(w, rejects, options.compression)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := newGFFIDs()
+
+	var group gffGroup
+	flush := func() error {
+		if len(group.features) == 0 {
+			return nil
+		}
+
+		err := liftGFFGroup(ctx, src, from, to, fw, &group, ids, options.intervalOptions)
+		group = gffGroup{}
+
+		return err
+	}
+
+	br := bufio.NewReader(dr)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read gff: %w", err)
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+
+			if strings.HasPrefix(line, gffFASTADirective) {
+				break
+			}
+
+			if strings.HasPrefix(line, gffSequenceRegionDirective) {
+				continue
+			}
+
+			if line == gffResolutionDirective {
+				ids = newGFFIDs()
+
+				// Only written to the lifted features, as it marks that all the
+				// features so far have been resolved.
+				if _, err := fw.bw.WriteString(line + "\n"); err != nil {
+					return nil, fmt.Errorf("could not write directive: %w", err)
+				}
+
+				continue
+			}
+
+			if err := fw.header(line); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		feature, ok := parseGFFFeature(line)
+		if !ok {
+			if err := fw.rejected(line, RejectInvalidFeature); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		var related, parentRejected bool
+		var parentPlacement *gffPlacement
+		for _, parent := range feature.parents {
+			related = related || group.ids[parent]
+			parentRejected = parentRejected || ids.rejected[parent]
+
+			if placement, ok := ids.lifted[parent]; ok && parentPlacement == nil {
+				parentPlacement = &placement
+			}
+		}
+
+		if parentRejected && !related {
+			for _, id := range feature.ids {
+				ids.rejected[id] = true
+			}
+
+			if err := fw.rejected(line, RejectRelatedFeatureRejected); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if !related {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+
+		if group.ids == nil {
+			group.ids = make(map[string]bool)
+		}
+
+		if group.parent == nil {
+			group.parent = parentPlacement
+		}
+
+		for _, id := range feature.ids {
+			group.ids[id] = true
+		}
+
+		group.features = append(group.features, *feature)
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+
+	return fw.stats, nil
+}
+
+// liftGFFGroup lifts a hierarchy of related features, rejecting them all if
+// any can not be lifted.
+func liftGFFGroup(ctx context.Context, src ChainSource, from, to types.Reference, fw *featureWriter, group *gffGroup, ids *gffIDs, opts []IntervalOption) error {
+	segments := make([]*IntervalSegment, len(group.features))
+	reasons := make([]string, len(group.features))
+
+	var rejected bool
+	for i, feature := range group.features {
+		segment, reason, err := liftFeatureInterval(ctx, src, from, to, names.Chromosome(feature.fields[0]), feature.start, feature.end, opts)
+		if err != nil {
+			return err
+		}
+
+		if reason == "" && segments[0] != nil &&
+			(segment.Chromosome != segments[0].Chromosome || segment.Strand != segments[0].Strand) {
+			reason = ErrSplit.Error()
+		}
+
+		if reason == "" && group.parent != nil &&
+			(segment.Chromosome != group.parent.chromosome || segment.Strand != group.parent.strand) {
+			reason = ErrSplit.Error()
+		}
+
+		segments[i], reasons[i] = segment, reason
+		rejected = rejected || reason != ""
+	}
+
+	for i, feature := range group.features {
+		if rejected {
+			reason := reasons[i]
+			if reason == "" {
+				reason = RejectRelatedFeatureRejected
+			}
+
+			for _, id := range feature.ids {
+				ids.rejected[id] = true
+			}
+
+			if err := fw.rejected(feature.line, reason); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, id := range feature.ids {
+			ids.lifted[id] = gffPlacement{chromosome: segments[i].Chromosome, strand: segments[i].Strand}
+		}
+
+		lifted := make([]string, len(feature.fields))
+		copy(lifted, feature.fields)
+
+		lifted[0] = formatChromosome(feature.fields[0], segments[i].Chromosome)
+		lifted[3] = strconv.FormatInt(segments[i].Start, 10)
+		lifted[4] = strconv.FormatInt(segments[i].End, 10)
+
+		if segments[i].Strand == "-" {
+			lifted[6] = flipStrand(feature.fields[6])
+		}
+
+		if err := fw.lifted(strings.Join(lifted, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseGFFFeature parses a GFF3 or GTF feature line.
+func parseGFFFeature(line string) (*gffFeature, bool) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 9 {
+		return nil, false
+	}
+
+	start, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	end, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	feature := &gffFeature{
+		line:   line,
+		fields: fields,
+		start:  start,
+		end:    end,
+	}
+
+	for _, attribute := range strings.Split(fields[8], ";") {
+		attribute = strings.TrimSpace(attribute)
+
+		// GFF3 attributes are key=value pairs.
+		if key, value, ok := strings.Cut(attribute, "="); ok && !strings.Contains(key, " ") {
+			switch key {
+			case "ID":
+				feature.ids = append(feature.ids, value)
+			case "Parent":
+				feature.parents = append(feature.parents, strings.Split(value, ",")...)
+			}
+
+			continue
+		}
+
+		// GTF attributes are key "value" pairs, and are grouped by gene.
+		if key, value, ok := strings.Cut(attribute, " "); ok && key == "gene_id" {
+			geneID := strings.Trim(strings.TrimSpace(value), `"`)
+
+			feature.ids = append(feature.ids, geneID)
+			feature.parents = append(feature.parents, geneID)
+		}
+	}
+
+	return feature, true
+}
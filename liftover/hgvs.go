@@ -0,0 +1,89 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/hgvs"
+)
+
+// LiftHGVS lifts a genomic HGVS variant description from one reference genome
+// assembly to another, eg. "NC_000017.10:g.41245466G>A" (GRCh37) to
+// "NC_000017.11:g.43093449G>A" (GRCh38). The accession of the description
+// must be a chromosome of the source assembly, and is replaced with the
+// accession of the chromosome the variant was lifted to.
+//
+// Every position of the variant (or the flanking positions of an insertion)
+// must be lifted by a single chain, without any gaps in either assembly, or an
+// unmapped error is returned (see UnmappedReason). Variants lifted
+// onto the negative strand have their sequences reverse complemented, but are
+// not shifted to the most 3' position as HGVS requires (which depends on the
+// target sequence).
+func LiftHGVS(ctx context.Context, src ChainSource, from, to types.Reference, description string) (string, error) {
+	v, err := hgvs.Parse(description)
+	if err != nil {
+		return "", err
+	}
+
+	chromosome, references, err := hgvs.ResolveAccession(v.Accession)
+	if err != nil {
+		return "", err
+	}
+
+	if !slices.Contains(references, from) {
+		return "", fmt.Errorf("accession %s is not a chromosome of %s", v.Accession, from)
+	}
+
+	lifted := *v
+
+	var strand string
+	if v.Start == v.End {
+		result, err := Lift(ctx, src, from, to, chromosome, v.Start)
+		if err != nil {
+			return "", err
+		}
+
+		lifted.Start, lifted.End = result.Position, result.Position
+		chromosome, strand = result.Chromosome, result.Strand
+	} else {
+		result, err := LiftInterval(ctx, src, from, to, chromosome, v.Start, v.End, WithMinMatch(1))
+		if err != nil {
+			return "", err
+		}
+
+		// Every base must be matched (no gaps in the source), and the span must be
+		// unchanged (no gaps in the target).
+		segment := result.Segments[0]
+		if segment.Matched < 1 || segment.End-segment.Start != v.End-v.Start {
+			return "", fmt.Errorf("variant %s spans a gap in the alignment: %w", description, ErrSplit)
+		}
+
+		lifted.Start, lifted.End = segment.Start, segment.End
+		chromosome, strand = segment.Chromosome, segment.Strand
+	}
+
+	lifted.Accession, err = hgvs.Accession(to, chromosome)
+	if err != nil {
+		return "", err
+	}
+
+	if strand == "-" {
+		lifted.Ref, lifted.Alt = reverseComplement(v.Ref), reverseComplement(v.Alt)
+	}
+
+	return lifted.String(), nil
+}
@@ -0,0 +1,206 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/zymatik-com/genobase/types"
+)
+
+// DefaultMinMatch is the default minimum fraction of bases that must remap
+// for an interval to be lifted (the same as UCSC liftOver).
+const DefaultMinMatch = 0.95
+
+type intervalOptions struct {
+	minMatch float64
+	multiple bool
+}
+
+// IntervalOption configures the behavior of LiftInterval.
+type IntervalOption func(*intervalOptions)
+
+// WithMinMatch sets the minimum fraction of bases that must remap for an
+// interval to be lifted (equivalent to liftOver -minMatch).
+func WithMinMatch(minMatch float64) IntervalOption {
+	return func(opts *intervalOptions) {
+		opts.minMatch = minMatch
+	}
+}
+
+// WithMultiple allows an interval to be lifted to multiple target regions,
+// one per chain (equivalent to liftOver -multiple).
+func WithMultiple() IntervalOption {
+	return func(opts *intervalOptions) {
+		opts.multiple = true
+	}
+}
+
+// IntervalSegment is a region of the target genome that an interval was
+// lifted to.
+type IntervalSegment struct {
+	Chromosome types.Chromosome // Chromosome in the target genome.
+	Start      int64            // Start position in the target genome (1-based, inclusive).
+	End        int64            // End position in the target genome (1-based, inclusive).
+	Strand     string           // Strand in the target genome ('+' or '-').
+	ChainID    int64            // ID of the chain used for the liftover.
+	Score      int64            // Alignment score of the chain used for the liftover.
+	Matched    float64          // Fraction of the source interval remapped by this segment.
+}
+
+// IntervalResult is the result of lifting an interval from one reference
+// genome to another.
+type IntervalResult struct {
+	Reference types.Reference   // Target reference genome assembly.
+	Segments  []IntervalSegment // Target regions, ordered by descending chain score.
+	Matched   float64           // Fraction of the source interval remapped across all chains.
+}
+
+// LiftInterval lifts the given interval (1-based, inclusive) from the reference
+// genome to the query genome by walking the alignment blocks of every chain that
+// overlaps it. Each chain produces a single segment spanning the first to the
+// last remapped base. Without WithMultiple, the interval must be lifted by
+// exactly one chain.
+func LiftInterval(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, start, end int64, opts ...IntervalOption) (*IntervalResult, error) {
+	if start > end {
+		return nil, fmt.Errorf("invalid interval %d-%d", start, end)
+	}
+
+	options := intervalOptions{
+		minMatch: DefaultMinMatch,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	chains, err := getChains(ctx, src, from, to, chromosome, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	size := end - start + 1
+
+	result := &IntervalResult{
+		Reference: to,
+	}
+
+	var segments []IntervalSegment
+	for i := range chains {
+		segment, matched, err := liftIntervalWithChain(ctx, src, &chains[i], start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		if matched == 0 {
+			continue
+		}
+
+		result.Matched += float64(matched) / float64(size)
+
+		if segment.Matched >= options.minMatch {
+			segments = append(segments, *segment)
+		}
+	}
+
+	if len(segments) == 0 {
+		if result.Matched >= options.minMatch {
+			return nil, fmt.Errorf("interval %s:%d-%d: %w", chromosome, start, end, ErrSplit)
+		}
+
+		return nil, fmt.Errorf("interval %s:%d-%d (%.2f remapped): %w", chromosome, start, end, result.Matched, ErrDeleted)
+	}
+
+	if len(segments) > 1 && !options.multiple {
+		return nil, fmt.Errorf("interval %s:%d-%d (%d chains): %w", chromosome, start, end, len(segments), ErrDuplicated)
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].Score > segments[j].Score
+	})
+
+	result.Segments = segments
+
+	return result, nil
+}
+
+// liftIntervalWithChain walks the alignment blocks of the chain that overlap
+// the interval, and returns the target segment and the number of bases remapped.
+func liftIntervalWithChain(ctx context.Context, src ChainSource, chain *types.Chain, start, end int64) (*IntervalSegment, int64, error) {
+	// Work in 0-based, half-open offsets from the start of the chain.
+	startOffset := max(start-1-chain.RefStart, 0)
+	endOffset := min(end-chain.RefStart, chain.RefEnd-chain.RefStart)
+
+	var matched int64
+	queryStart, queryEnd := int64(-1), int64(-1)
+
+	for offset := startOffset; offset < endOffset; {
+		alignment, err := src.GetAlignment(ctx, chain.ID, offset+1)
+		if err != nil {
+			if UnmappedReason(withUnmappedReason(err, ErrGap)) != nil {
+				// No more blocks in the chain.
+				break
+			}
+
+			return nil, 0, fmt.Errorf("could not get alignment: %w", err)
+		}
+
+		if alignment.RefOffset >= endOffset {
+			break
+		}
+
+		overlapStart := max(offset, alignment.RefOffset)
+		overlapEnd := min(endOffset, alignment.RefOffset+alignment.Size)
+
+		if overlapEnd > overlapStart {
+			blockQueryStart := alignment.QueryOffset + (overlapStart - alignment.RefOffset)
+			blockQueryEnd := blockQueryStart + (overlapEnd - overlapStart)
+
+			if queryStart == -1 {
+				queryStart = blockQueryStart
+			}
+			queryEnd = blockQueryEnd
+
+			matched += overlapEnd - overlapStart
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		offset = alignment.RefOffset + alignment.Size
+	}
+
+	if matched == 0 {
+		return nil, 0, nil
+	}
+
+	segment := &IntervalSegment{
+		Chromosome: chain.QueryName,
+		Start:      chain.QueryStart + queryStart + 1,
+		End:        chain.QueryStart + queryEnd,
+		Strand:     chain.QueryStrand,
+		ChainID:    chain.ID,
+		Score:      chain.Score,
+		Matched:    float64(matched) / float64(end-start+1),
+	}
+
+	if chain.QueryStrand == "-" {
+		// Query coordinates on the negative strand are relative to the end of the
+		// reverse complemented query chromosome.
+		segment.Start, segment.End = chain.QuerySize-segment.End+1, chain.QuerySize-segment.Start+1
+	}
+
+	return segment, matched, nil
+}
@@ -19,112 +19,276 @@ package liftover
 import (
 	"context"
 	"fmt"
+	"sort"
 
-	"github.com/Workiva/go-datastructures/augmentedtree"
-	"github.com/cheggaaa/pb/v3"
-	"github.com/zymatik-com/genobase"
 	"github.com/zymatik-com/genobase/types"
-	"github.com/zymatik-com/nucleo/liftover/chainfile"
 )
 
 // ChainSource is a source of chain and alignment information.
 type ChainSource interface {
-	// GetChain returns the chain for the given chromosome and position.
-	GetChain(ctx context.Context, from types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error)
-	// GetAlignment returns the alignment for the given chain and offset from the
-	// start of the chain.
+	// Pairs returns the (from, to) pairs of reference genome assemblies that the
+	// source can lift between. A source that does not know its assemblies may
+	// return no pairs, and will be used for whatever pair it is asked to lift.
+	Pairs(ctx context.Context) ([]Hop, error)
+	// GetChain returns the chain for the given chromosome and position, when
+	// lifting from one reference genome assembly to another.
+	GetChain(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error)
+	// GetAlignment returns the first alignment block in the given chain that
+	// ends at or after the given offset from the start of the chain. The offset
+	// may fall in a gap before the returned block.
 	GetAlignment(ctx context.Context, chainID int64, offset int64) (*types.Alignment, error)
 }
 
+// MultiChainSource is a ChainSource that can return every chain overlapping
+// a region, rather than just the first one.
+type MultiChainSource interface {
+	ChainSource
+	// GetChains returns all the chains overlapping the given chromosome region.
+	GetChains(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, start, end int64) ([]types.Chain, error)
+}
+
+// LiftResult is the result of lifting a position from one reference genome
+// to another.
+type LiftResult struct {
+	Reference  types.Reference  // Target reference genome assembly.
+	Chromosome types.Chromosome // Chromosome in the target genome (may differ from the source).
+	Position   int64            // Position in the target genome.
+	Strand     string           // Strand in the target genome ('+' or '-').
+	ChainID    int64            // ID of the chain used for the liftover.
+	Score      int64            // Alignment score of the chain used for the liftover.
+	// Approximate is set when the position fell in a gap of the chain, and the
+	// nearest aligned position was lifted instead (see WithNearest).
+	Approximate bool
+	// Distance is the offset (in source genome bases) from the requested
+	// position to the nearest aligned position that was lifted, negative if it
+	// is upstream. It is always zero for exact results.
+	Distance int64
+}
+
+type liftOptions struct {
+	nearest bool
+	workers int
+}
+
+// LiftOption configures the behavior of Lift, LiftAll and LiftBatch.
+type LiftOption func(*liftOptions)
+
+// WithNearest lifts the nearest aligned position (on either side, preferring
+// upstream if equidistant) when a position falls in a gap between the alignment
+// blocks of a chain, rather than failing with ErrGap. Such results are marked as
+// approximate. This is only suitable for approximate annotation (eg. assigning
+// a lifted probe to a gene), as the lifted position is not equivalent to the
+// requested position.
+func WithNearest() LiftOption {
+	return func(opts *liftOptions) {
+		opts.nearest = true
+	}
+}
+
 // Lift returns the position in the query genome for the given position in the
-// reference genome.
-func Lift(ctx context.Context, src ChainSource, from types.Reference, chromosome types.Chromosome, position int64) (int64, error) {
-	chain, err := src.GetChain(ctx, from, chromosome, position)
+// reference genome. If the position is covered by multiple chains, the first
+// chain returned by the source is used.
+func Lift(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, position int64, opts ...LiftOption) (*LiftResult, error) {
+	var options liftOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	chain, err := src.GetChain(ctx, from, to, chromosome, position)
 	if err != nil {
-		return -1, fmt.Errorf("could not get chain: %w", err)
+		return nil, fmt.Errorf("could not get chain: %w", withUnmappedReason(err, ErrDeleted))
+	}
+
+	return liftWithChain(ctx, src, to, chain, position, options)
+}
+
+// LiftAll returns the position in the query genome for every chain that covers
+// the given position in the reference genome, ordered by descending chain score
+// (with any approximate results last). If the source does not implement
+// MultiChainSource, at most one result will be returned.
+func LiftAll(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, position int64, opts ...LiftOption) ([]LiftResult, error) {
+	var options liftOptions
+	for _, opt := range opts {
+		opt(&options)
 	}
 
-	alignment, err := src.GetAlignment(ctx, chain.ID, position-chain.RefStart)
+	chains, err := getChains(ctx, src, from, to, chromosome, position, position)
 	if err != nil {
-		return -1, fmt.Errorf("position %d not found in chromosome %s: %w", position, chromosome, err)
+		return nil, err
 	}
 
-	queryPosition := chain.QueryStart + alignment.QueryOffset
-	if chain.QueryStrand == "+" {
-		queryPosition += position - (chain.RefStart + alignment.RefOffset)
-	} else {
-		queryPosition = chain.QueryEnd - (chain.RefStart + alignment.RefOffset)
+	var results []LiftResult
+	var liftErr error
+	for i := range chains {
+		result, err := liftWithChain(ctx, src, to, &chains[i], position, options)
+		if err != nil {
+			// The position may fall in a gap of one chain but be aligned in another.
+			liftErr = err
+			continue
+		}
+
+		results = append(results, *result)
+	}
+
+	if len(results) == 0 {
+		if liftErr == nil {
+			liftErr = fmt.Errorf("position %d not found in chromosome %s: %w", position, chromosome, ErrDeleted)
+		}
+
+		return nil, liftErr
 	}
 
-	return queryPosition, nil
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Approximate != results[j].Approximate {
+			return !results[i].Approximate
+		}
+
+		return results[i].Score > results[j].Score
+	})
+
+	return results, nil
 }
 
-// StoreChainFile stores the chain file in the database in a queryable format.
-func StoreChainFile(ctx context.Context, db *genobase.DB, from types.Reference, cf *chainfile.ChainFile, showProgress bool) error {
-	var bar *pb.ProgressBar
-	if showProgress {
-		total := 0
-		for _, chains := range cf.ChainsByChromosome {
-			total += int(chains.Len())
+// getChains returns the chains overlapping the given region. Sources that do
+// not implement MultiChainSource are probed at the start of the region and
+// then just past the end of each chain found, until the region is exhausted.
+func getChains(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, start, end int64) ([]types.Chain, error) {
+	if multiSrc, ok := src.(MultiChainSource); ok {
+		chains, err := multiSrc.GetChains(ctx, from, to, chromosome, start, end)
+		if err != nil {
+			return nil, fmt.Errorf("could not get chains: %w", withUnmappedReason(err, ErrDeleted))
 		}
 
-		bar = pb.StartNew(total)
-		defer bar.Finish()
+		return chains, nil
 	}
 
-	for _, chains := range cf.ChainsByChromosome {
-		var storeErr error
-		chains.Traverse(func(interval augmentedtree.Interval) {
-			if storeErr != nil {
-				return
+	var chains []types.Chain
+	for position := start; position <= end; {
+		chain, err := src.GetChain(ctx, from, to, chromosome, position)
+		if err != nil {
+			err = withUnmappedReason(err, ErrDeleted)
+			if len(chains) > 0 && UnmappedReason(err) != nil {
+				break
 			}
 
-			if bar != nil {
-				bar.Increment()
-			}
+			return nil, fmt.Errorf("could not get chain: %w", err)
+		}
 
-			chain := interval.(*chainfile.Chain)
-
-			chainID, err := db.StoreChain(ctx, from, &types.Chain{
-				Score:       chain.Score,
-				Ref:         from,
-				RefName:     chain.RefName,
-				RefSize:     chain.RefSize,
-				RefStrand:   chain.RefStrand,
-				RefStart:    chain.RefStart,
-				RefEnd:      chain.RefEnd,
-				QueryName:   chain.QueryName,
-				QuerySize:   chain.QuerySize,
-				QueryStrand: chain.QueryStrand,
-				QueryStart:  chain.QueryStart,
-				QueryEnd:    chain.QueryEnd,
-			})
-			if err != nil {
-				storeErr = fmt.Errorf("could not store chain: %w", err)
-				return
-			}
+		chains = append(chains, *chain)
+		position = chain.RefEnd + 1
+	}
+
+	return chains, nil
+}
+
+func liftWithChain(ctx context.Context, src ChainSource, to types.Reference, chain *types.Chain, position int64, options liftOptions) (*LiftResult, error) {
+	alignment, distance, err := findAlignment(ctx, src, chain, position, options)
+	if err != nil {
+		return nil, err
+	}
 
-			dbAlignments := make([]types.Alignment, chain.Alignments.Len())
+	result := liftWithAlignment(to, chain, alignment, position, distance)
+	return &result, nil
+}
 
-			chain.Alignments.Traverse(func(interval augmentedtree.Interval) {
-				alignment := interval.(*chainfile.Alignment)
+// findAlignment returns the alignment block of the chain that aligns the given
+// position, or with WithNearest, the nearest aligned position to it (and the
+// distance to that position).
+func findAlignment(ctx context.Context, src ChainSource, chain *types.Chain, position int64, options liftOptions) (*types.Alignment, int64, error) {
+	offset := position - chain.RefStart
 
-				dbAlignments = append(dbAlignments, types.Alignment{
-					RefOffset:   alignment.RefOffset,
-					QueryOffset: alignment.QueryOffset,
-					Size:        alignment.Size,
-				})
-			})
+	alignment, err := src.GetAlignment(ctx, chain.ID, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("position %d not found in chromosome %s: %w", position, chain.RefName, withUnmappedReason(err, ErrGap))
+	}
 
-			if err := db.StoreAlignments(ctx, chainID, dbAlignments); err != nil {
-				storeErr = fmt.Errorf("could not store alignments: %w", err)
-				return
-			}
-		})
-		if storeErr != nil {
-			return storeErr
+	// Positions are 1-based, while chain offsets are 0-based.
+	if offset <= alignment.RefOffset || offset > alignment.RefOffset+alignment.Size {
+		if !options.nearest || offset <= 0 || offset > alignment.RefOffset {
+			return nil, 0, fmt.Errorf("position %d not aligned in chain %d: %w", position, chain.ID, ErrGap)
 		}
+
+		alignment, distance, err := nearestAlignment(ctx, src, chain.ID, offset, alignment)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not find nearest aligned position to %d in chain %d: %w", position, chain.ID, err)
+		}
+
+		return alignment, distance, nil
+	}
+
+	return alignment, 0, nil
+}
+
+// liftWithAlignment lifts a position (plus the distance to the nearest aligned
+// position) using the alignment block that aligns it.
+func liftWithAlignment(to types.Reference, chain *types.Chain, alignment *types.Alignment, position, distance int64) LiftResult {
+	offset := position + distance - chain.RefStart
+	queryOffset := chain.QueryStart + alignment.QueryOffset + (offset - alignment.RefOffset)
+
+	queryPosition := queryOffset
+	if chain.QueryStrand == "-" {
+		// Query coordinates on the negative strand are relative to the end of the
+		// reverse complemented query chromosome.
+		queryPosition = chain.QuerySize - queryOffset + 1
+	}
+
+	return LiftResult{
+		Reference:   to,
+		Chromosome:  chain.QueryName,
+		Position:    queryPosition,
+		Strand:      chain.QueryStrand,
+		ChainID:     chain.ID,
+		Score:       chain.Score,
+		Approximate: distance != 0,
+		Distance:    distance,
+	}
+}
+
+// nearestAlignment returns the alignment block containing the nearest aligned
+// offset to an offset that falls in the gap before the next block, and the
+// distance to that offset.
+func nearestAlignment(ctx context.Context, src ChainSource, chainID int64, offset int64, next *types.Alignment) (*types.Alignment, int64, error) {
+	nextDistance := next.RefOffset + 1 - offset
+
+	// Sources can only find the first block ending at or after an offset, so
+	// search backwards in increasing steps for a block that ends before the gap.
+	var previous *types.Alignment
+	for step := int64(1); ; step *= 2 {
+		probe := max(offset-step, 1)
+
+		alignment, err := src.GetAlignment(ctx, chainID, probe)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if alignment.RefOffset+alignment.Size < offset {
+			previous = alignment
+			break
+		}
+
+		// There is no block before the gap, or it is further away than the next.
+		if probe == 1 || step >= nextDistance {
+			return next, nextDistance, nil
+		}
+	}
+
+	// Then walk forwards to the last block before the gap.
+	for {
+		alignment, err := src.GetAlignment(ctx, chainID, previous.RefOffset+previous.Size+1)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if alignment.RefOffset+alignment.Size >= offset {
+			break
+		}
+
+		previous = alignment
+	}
+
+	if previousDistance := offset - (previous.RefOffset + previous.Size); previousDistance <= nextDistance {
+		return previous, -previousDistance, nil
 	}
 
-	return nil
+	return next, nextDistance, nil
 }
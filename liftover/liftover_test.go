@@ -13,24 +13,27 @@
 package liftover_test
 
 import (
+	"bytes"
 	"context"
-	"encoding/csv"
+	"errors"
 	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 
-	"github.com/brentp/vcfgo"
 	"github.com/neilotoole/slogt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/zymatik-com/genobase"
 	"github.com/zymatik-com/genobase/types"
 	"github.com/zymatik-com/nucleo/compress"
+	"github.com/zymatik-com/nucleo/fasta"
 	"github.com/zymatik-com/nucleo/liftover"
 	"github.com/zymatik-com/nucleo/liftover/chainfile"
-	"github.com/zymatik-com/nucleo/names"
+	"github.com/zymatik-com/nucleo/liftover/evaluate"
 )
 
 // A simple test to check if the liftover works as expected by validating the
@@ -43,11 +46,7 @@ import (
 //     ClinVar Variants. Genes 2023, 14, 1875. https://doi.org/10.3390/genes14101875.
 func TestLiftOver(t *testing.T) {
 	ctx := context.Background()
-	db, err := genobase.Open(ctx, slogt.New(t), "")
-	require.NoError(t, err)
-	t.Cleanup(func() {
-		require.NoError(t, db.Close())
-	})
+	db := openDB(t)
 
 	// Initialize database from a chain file.
 	{
@@ -66,7 +65,7 @@ func TestLiftOver(t *testing.T) {
 		cf, err := chainfile.Read(dr)
 		require.NoError(t, err)
 
-		err = liftover.StoreChainFile(ctx, db, types.ReferenceGRCh37, cf, false)
+		err = liftover.StoreChainFile(ctx, db, types.ReferenceGRCh37, types.ReferenceGRCh38, cf)
 		require.NoError(t, err)
 	}
 
@@ -86,165 +85,1246 @@ func TestLiftOver(t *testing.T) {
 		cf, err := chainfile.Read(dr)
 		require.NoError(t, err)
 
-		err = liftover.StoreChainFile(ctx, db, types.ReferenceNCBI36, cf, false)
+		err = liftover.StoreChainFile(ctx, db, types.ReferenceNCBI36, types.ReferenceGRCh38, cf)
 		require.NoError(t, err)
 	}
 
+	src := liftover.NewDBSource(db,
+		liftover.Hop{From: types.ReferenceGRCh37, To: types.ReferenceGRCh38},
+		liftover.Hop{From: types.ReferenceNCBI36, To: types.ReferenceGRCh38})
+
+	t.Run("Pairs", func(t *testing.T) {
+		pairs, err := src.Pairs(ctx)
+		require.NoError(t, err)
+
+		assert.Equal(t, []liftover.Hop{
+			{From: types.ReferenceGRCh37, To: types.ReferenceGRCh38},
+			{From: types.ReferenceNCBI36, To: types.ReferenceGRCh38},
+		}, pairs)
+
+		_, err = liftover.Lift(ctx, src, types.ReferenceGRCh37, types.ReferenceTelomereToTelomereV2, "1", 1000000)
+		require.Error(t, err)
+	})
+
 	t.Run("NCBI36 To GRCh38", func(t *testing.T) {
-		ncbi36SNPs, err := readLegacySNPs("../testdata/snp130.txt.gz")
+		ncbi36SNPs := readLegacySNPs(t, "../testdata/snp130.txt.gz")
+		grch38SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh38_20231230.vcf.gz", types.ReferenceGRCh38)
+
+		report, err := evaluate.Evaluate(ctx, src, ncbi36SNPs, grch38SNPs)
 		require.NoError(t, err)
 
-		grch38SNPs, err := readClinVarSNPs("../testdata/clinvar_GRCh38_20231230.vcf.gz")
+		// Lifting this ancient dbSNP build from 2009 to GRCh38 is not perfect.
+		// Upon inspection, I've checked the majority against other liftover tools
+		// and we seem consistent.
+		assert.Greater(t, report.Concordant, 500)
+		assert.Greater(t, report.Concordance(), 0.85)
+	})
+
+	t.Run("GRCh37 To GRCh38", func(t *testing.T) {
+		grch37SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+		grch38SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh38_20231230.vcf.gz", types.ReferenceGRCh38)
+
+		// Any failure other than an unmapped position is returned as an error.
+		report, err := evaluate.Evaluate(ctx, src, grch37SNPs, grch38SNPs)
 		require.NoError(t, err)
 
-		var foundInBoth, successFullyLifted int
-		for _, snp := range ncbi36SNPs {
-			if _, ok := grch38SNPs[snp.id]; !ok {
-				continue
-			}
+		assert.Greater(t, report.Concordant, 1000)
+		assert.Greater(t, report.Concordance(), 0.995)
+	})
 
-			foundInBoth++
+	t.Run("Batch", func(t *testing.T) {
+		grch37SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
 
-			result, err := liftover.Lift(ctx, db, types.ReferenceNCBI36, snp.chromosome, snp.position)
-			if err != nil {
-				continue
+		requireBatchMatchesLift(t, src, types.ReferenceGRCh37, types.ReferenceGRCh38, grch37SNPs)
+	})
+}
+
+func TestLiftBatch(t *testing.T) {
+	ctx := context.Background()
+
+	cf := readChainFile(t, "../testdata/GRCh37_to_GRCh38.chain.gz")
+
+	grch37SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+
+	requireBatchMatchesLift(t, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, grch37SNPs, liftover.WithWorkers(4))
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := liftover.LiftBatch(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, []liftover.Locus{
+			{Chromosome: "1", Position: 1000000},
+		})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func BenchmarkLiftBatch(b *testing.B) {
+	ctx := context.Background()
+
+	cf := readChainFile(b, "../testdata/GRCh37_to_GRCh38.chain.gz")
+
+	grch37SNPs := readClinVarSNPs(b, "../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+
+	var loci []liftover.Locus
+	for _, locus := range grch37SNPs.Variants {
+		loci = append(loci, locus)
+	}
+
+	b.Run("Lift", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, locus := range loci {
+				_, _ = liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, locus.Chromosome, locus.Position)
 			}
+		}
+	})
+
+	b.Run("LiftBatch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := liftover.LiftBatch(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, loci, liftover.WithWorkers(runtime.NumCPU()))
+			require.NoError(b, err)
+		}
+	})
+}
+
+func TestCachedSource(t *testing.T) {
+	ctx := context.Background()
+
+	cf := readChainFile(t, "../testdata/GRCh37_to_GRCh38.chain.gz")
+
+	grch37SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+
+	var loci []liftover.Locus
+	for _, locus := range grch37SNPs.Variants {
+		loci = append(loci, locus)
+	}
+
+	liftAll := func(t *testing.T, src liftover.ChainSource) {
+		var wg sync.WaitGroup
+		for worker := 0; worker < 4; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+
+				for i := worker; i < len(loci); i += 4 {
+					expected, expectedErr := liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, loci[i].Chromosome, loci[i].Position)
+					result, err := liftover.Lift(ctx, src, types.ReferenceGRCh37, types.ReferenceGRCh38, loci[i].Chromosome, loci[i].Position)
+					if expectedErr != nil {
+						assert.Equal(t, liftover.UnmappedReason(expectedErr), liftover.UnmappedReason(err))
+						continue
+					}
+
+					assert.NoError(t, err)
+					assert.Equal(t, expected, result)
+				}
+			}(worker)
+		}
+
+		wg.Wait()
+	}
 
-			if result == grch38SNPs[snp.id].position {
-				successFullyLifted++
+	t.Run("Hits", func(t *testing.T) {
+		src := liftover.NewCachedSource(cf, 10*len(loci))
+
+		// Errors are not cached.
+		var notFound uint64
+		for _, locus := range loci {
+			if _, err := cf.GetChain(ctx, types.ReferenceGRCh37, types.ReferenceGRCh38, locus.Chromosome, locus.Position); err != nil {
+				notFound++
 			}
 		}
 
-		// Lifting this ancient dbSNP build from 2009 to GRCh38 is not perfect.
-		// Upon inspection, I've checked the majority against other liftover tools
-		// and we seem consistent.
-		assert.Greater(t, successFullyLifted, 500)
-		assert.Greater(t, float64(successFullyLifted)/float64(foundInBoth), 0.85)
+		liftAll(t, src)
+
+		stats := src.Stats()
+		assert.Equal(t, uint64(len(loci)), stats.ChainHits+stats.ChainMisses)
+		// Nearby positions share chains and alignment blocks.
+		assert.Less(t, stats.ChainMisses, (uint64(len(loci))-notFound)/10)
+		assert.NotZero(t, stats.AlignmentHits)
+
+		liftAll(t, src)
+
+		repeated := src.Stats()
+		assert.Equal(t, uint64(len(loci))-notFound, repeated.ChainHits-stats.ChainHits)
+		assert.Equal(t, notFound, repeated.ChainMisses-stats.ChainMisses)
+		assert.Zero(t, repeated.Evictions)
 	})
 
-	t.Run("GRCh37 To GRCh38", func(t *testing.T) {
-		grch37SNPs, err := readClinVarSNPs("../testdata/clinvar_GRCh37_20231230.vcf.gz")
+	t.Run("Overlapping Chains", func(t *testing.T) {
+		cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 100 300 1 1000 + 100 300 1
+200
+
+chain 200 1 1000 + 200 250 2 1000 + 0 50 2
+50
+`))
 		require.NoError(t, err)
 
-		grch38SNPs, err := readClinVarSNPs("../testdata/clinvar_GRCh38_20231230.vcf.gz")
+		for name, tc := range map[string]struct {
+			src    liftover.ChainSource
+			misses uint64
+		}{
+			"Multiple Chain Source": {src: cf, misses: 4},
+			// Without GetChains, chains can only be cached for their positions.
+			"Chain Source": {src: struct{ liftover.ChainSource }{cf}, misses: 6},
+		} {
+			t.Run(name, func(t *testing.T) {
+				src := liftover.NewCachedSource(tc.src, 100)
+
+				for _, position := range []int64{150, 160, 220, 221, 260, 270, 150, 220} {
+					expected, err := cf.GetChain(ctx, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", position)
+					require.NoError(t, err)
+
+					chain, err := src.GetChain(ctx, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", position)
+					require.NoError(t, err)
+
+					assert.Equal(t, expected.ID, chain.ID, position)
+				}
+
+				stats := src.Stats()
+				assert.Equal(t, tc.misses, stats.ChainMisses)
+				assert.Equal(t, 8-tc.misses, stats.ChainHits)
+			})
+		}
+	})
+
+	t.Run("Evictions", func(t *testing.T) {
+		src := liftover.NewCachedSource(cf, 100)
+
+		liftAll(t, src)
+
+		stats := src.Stats()
+		assert.Equal(t, 100, stats.Entries)
+		assert.NotZero(t, stats.Evictions)
+	})
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Inconsistent", func(t *testing.T) {
+		cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 100 2 1000 + 0 100 1
+100
+`))
 		require.NoError(t, err)
 
-		var foundInBoth, successFullyLifted int
-		for _, snp := range grch37SNPs {
-			if _, ok := grch38SNPs[snp.id]; !ok {
-				continue
-			}
+		reverse, err := chainfile.Read(strings.NewReader(`chain 100 2 1000 + 0 100 1 1000 + 200 300 1
+100
+`))
+		require.NoError(t, err)
+
+		result, err := liftover.RoundTrip(ctx, cf, reverse, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 50)
+		require.NoError(t, err)
+
+		assert.False(t, result.Consistent)
+		assert.Equal(t, int64(50), result.Forward.Position)
+		assert.Equal(t, int64(250), result.Reverse.Position)
+
+		inverted, err := cf.Invert()
+		require.NoError(t, err)
+
+		result, err = liftover.RoundTrip(ctx, cf, inverted, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 50)
+		require.NoError(t, err)
+
+		assert.True(t, result.Consistent)
+
+		_, err = liftover.RoundTrip(ctx, cf, reverse, types.ReferenceGRCh37, types.ReferenceGRCh38, "X", 50)
+		require.ErrorIs(t, err, liftover.ErrNoChain)
+	})
+
+	t.Run("Nearest", func(t *testing.T) {
+		cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 100 2 1000 + 0 90 1
+40 10 0
+50
+`))
+		require.NoError(t, err)
+
+		inverted, err := cf.Invert()
+		require.NoError(t, err)
+
+		_, err = liftover.RoundTrip(ctx, cf, inverted, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 45)
+		require.ErrorIs(t, err, liftover.ErrGap)
+
+		result, err := liftover.RoundTrip(ctx, cf, inverted, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 45, liftover.WithNearest())
+		require.NoError(t, err)
+
+		assert.True(t, result.Forward.Approximate)
+		assert.False(t, result.Consistent)
+	})
+
+	t.Run("GRCh37 To GRCh38", func(t *testing.T) {
+		cf := readChainFile(t, "../testdata/GRCh37_to_GRCh38.chain.gz")
+
+		inverted, err := cf.Invert()
+		require.NoError(t, err)
+
+		grch37SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+
+		var loci []liftover.Locus
+		for _, locus := range grch37SNPs.Variants {
+			loci = append(loci, locus)
+		}
+
+		results, summary, err := liftover.RoundTripBatch(ctx, cf, inverted, types.ReferenceGRCh37, types.ReferenceGRCh38, loci)
+		require.NoError(t, err)
 
-			foundInBoth++
+		assert.Equal(t, len(loci), summary.Total)
+		assert.Equal(t, summary.Total, summary.Consistent+summary.Inconsistent+summary.Unmapped+summary.ReverseUnmapped)
+		assert.Greater(t, float64(summary.Consistent)/float64(summary.Total-summary.Unmapped), 0.98)
 
-			result, err := liftover.Lift(ctx, db, types.ReferenceGRCh37, snp.chromosome, snp.position)
+		for i, locus := range loci {
+			expected, err := liftover.RoundTrip(ctx, cf, inverted, types.ReferenceGRCh37, types.ReferenceGRCh38, locus.Chromosome, locus.Position)
 			if err != nil {
+				assert.Nil(t, results[i].Forward)
+				assert.Equal(t, liftover.UnmappedReason(err), liftover.UnmappedReason(results[i].Err))
 				continue
 			}
 
-			if result == grch38SNPs[snp.id].position {
-				successFullyLifted++
-			}
+			assert.Equal(t, expected.Forward, results[i].Forward)
+			assert.Equal(t, expected.Reverse, results[i].Reverse)
+			assert.Equal(t, expected.Consistent, results[i].Consistent)
 		}
+	})
+}
+
+func TestStoreChainFile(t *testing.T) {
+	db := openDB(t)
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 100 200 1 1000 + 200 300 1
+100
+
+chain 100 1 1000 + 300 400 1 1000 + 500 600 2
+100
 
-		assert.Greater(t, successFullyLifted, 1000)
-		assert.Greater(t, float64(successFullyLifted)/float64(foundInBoth), 0.995)
+chain 100 2 1000 + 100 200 2 1000 + 0 100 3
+100
+`))
+	require.NoError(t, err)
+
+	// Interrupt the first attempt part way through chromosome 1.
+	ctx, cancel := context.WithCancel(context.Background())
+	err = liftover.StoreChainFile(ctx, db, types.ReferenceGRCh37, types.ReferenceGRCh38, cf,
+		liftover.WithProgress(func(stored, total int) {
+			if stored == 1 {
+				cancel()
+			}
+		}))
+	require.ErrorIs(t, err, context.Canceled)
+
+	src := liftover.NewDBSource(db)
+
+	ctx = context.Background()
+
+	var progress [][2]int
+	err = liftover.StoreChainFile(ctx, db, types.ReferenceGRCh37, types.ReferenceGRCh38, cf,
+		liftover.WithProgress(func(stored, total int) {
+			progress = append(progress, [2]int{stored, total})
+		}))
+	require.NoError(t, err)
+
+	assert.Equal(t, [][2]int{{1, 3}, {2, 3}, {3, 3}}, progress)
+
+	// Storing the chain file again should not store anything.
+	progress = nil
+	err = liftover.StoreChainFile(ctx, db, types.ReferenceGRCh37, types.ReferenceGRCh38, cf,
+		liftover.WithProgress(func(stored, total int) {
+			progress = append(progress, [2]int{stored, total})
+		}))
+	require.NoError(t, err)
+
+	assert.Equal(t, [][2]int{{2, 3}, {3, 3}}, progress)
+
+	for position, expected := range map[int64]int64{150: 250, 350: 550} {
+		result, err := liftover.Lift(ctx, src, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", position)
+		require.NoError(t, err)
+
+		assert.Equal(t, expected, result.Position)
+	}
+
+	result, err := liftover.Lift(ctx, src, types.ReferenceGRCh37, types.ReferenceGRCh38, "2", 101)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), result.Position)
+
+	// Stored chains can not be replaced, even by chains with the same number
+	// and total score.
+	updated, err := chainfile.Read(strings.NewReader(`chain 100 2 1000 + 100 200 2 1000 + 10 110 3
+100
+`))
+	require.NoError(t, err)
+
+	err = liftover.StoreChainFile(ctx, db, types.ReferenceGRCh37, types.ReferenceGRCh38, updated)
+	require.ErrorIs(t, err, liftover.ErrChainsDiffer)
+
+	result, err = liftover.Lift(ctx, src, types.ReferenceGRCh37, types.ReferenceGRCh38, "2", 101)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), result.Position)
+
+	// Storing a chain file with other chromosomes should keep the stored ones.
+	added, err := chainfile.Read(strings.NewReader(`chain 100 3 1000 + 100 200 3 1000 + 0 100 4
+100
+`))
+	require.NoError(t, err)
+
+	err = liftover.StoreChainFile(ctx, db, types.ReferenceGRCh37, types.ReferenceGRCh38, added)
+	require.NoError(t, err)
+
+	for chromosome, expected := range map[types.Chromosome]int64{"1": 250, "2": 50, "3": 50} {
+		result, err := liftover.Lift(ctx, src, types.ReferenceGRCh37, types.ReferenceGRCh38, chromosome, 150)
+		require.NoError(t, err)
+
+		assert.Equal(t, expected, result.Position)
+	}
+
+	// Chains stored without a target assembly are not used for any pair.
+	legacyID, err := db.StoreChain(ctx, types.ReferenceNCBI36, &types.Chain{
+		Score:       100,
+		Ref:         types.ReferenceNCBI36,
+		RefName:     "1",
+		RefSize:     1000,
+		RefStrand:   "+",
+		RefStart:    0,
+		RefEnd:      100,
+		QueryName:   "1",
+		QuerySize:   1000,
+		QueryStrand: "+",
+		QueryStart:  10,
+		QueryEnd:    110,
 	})
+	require.NoError(t, err)
+
+	err = db.StoreAlignments(ctx, legacyID, []types.Alignment{{Size: 100}})
+	require.NoError(t, err)
+
+	_, err = liftover.Lift(ctx, src, types.ReferenceNCBI36, types.ReferenceGRCh38, "1", 50)
+	require.ErrorIs(t, err, os.ErrNotExist)
 }
 
-type snp struct {
-	id         int64
-	chromosome types.Chromosome
-	position   int64
+func TestLiftAll(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 100 200 2 500 - 50 150 1
+100
+
+chain 200 1 1000 + 150 300 3 1000 + 0 150 2
+150
+`))
+	require.NoError(t, err)
+
+	result, err := liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 101)
+	require.NoError(t, err)
+
+	assert.Equal(t, &liftover.LiftResult{
+		Reference:  types.ReferenceGRCh38,
+		Chromosome: "2",
+		Position:   450,
+		Strand:     "-",
+		ChainID:    1,
+		Score:      100,
+	}, result)
+
+	_, err = liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 500)
+	require.ErrorIs(t, err, liftover.ErrDeleted)
+
+	_, err = liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "X", 101)
+	require.ErrorIs(t, err, liftover.ErrNoChain)
+
+	results, err := liftover.LiftAll(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 160)
+	require.NoError(t, err)
+
+	assert.Equal(t, []liftover.LiftResult{
+		{
+			Reference:  types.ReferenceGRCh38,
+			Chromosome: "3",
+			Position:   10,
+			Strand:     "+",
+			ChainID:    2,
+			Score:      200,
+		},
+		{
+			Reference:  types.ReferenceGRCh38,
+			Chromosome: "2",
+			Position:   391,
+			Strand:     "-",
+			ChainID:    1,
+			Score:      100,
+		},
+	}, results)
 }
 
-func readClinVarSNPs(path string) (map[int64]snp, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func TestLiftNearest(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 100 2 1000 + 0 110 1
+10 1 1
+9 1 1
+19 10 20
+50
+`))
+	require.NoError(t, err)
+
+	_, err = liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 43)
+	require.ErrorIs(t, err, liftover.ErrGap)
+
+	tests := []struct {
+		position int64
+		expected int64
+		distance int64
+	}{
+		{position: 30, expected: 30},
+		{position: 11, expected: 10, distance: -1},
+		{position: 43, expected: 40, distance: -3},
+		{position: 48, expected: 61, distance: 3},
 	}
 
-	dr, err := compress.Decompress(f)
-	if err != nil {
-		return nil, err
+	for _, tt := range tests {
+		result, err := liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", tt.position, liftover.WithNearest())
+		require.NoError(t, err)
+
+		assert.Equal(t, &liftover.LiftResult{
+			Reference:   types.ReferenceGRCh38,
+			Chromosome:  "2",
+			Position:    tt.expected,
+			Strand:      "+",
+			ChainID:     1,
+			Score:       100,
+			Approximate: tt.distance != 0,
+			Distance:    tt.distance,
+		}, result, tt.position)
 	}
-	defer dr.Close()
 
-	vcfReader, err := vcfgo.NewReader(dr, false)
-	if err != nil {
-		return nil, err
+	results, err := liftover.LiftAll(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 48, liftover.WithNearest())
+	require.NoError(t, err)
+
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Approximate)
+	assert.Equal(t, int64(61), results[0].Position)
+}
+
+func TestLiftInterval(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 100 2 1000 + 0 110 1
+40 10 20
+50
+
+chain 200 1 1000 + 120 300 3 1000 + 0 180 2
+180
+
+chain 50 1 1000 + 150 250 4 1000 - 0 100 3
+100
+`))
+	require.NoError(t, err)
+
+	t.Run("Single Block", func(t *testing.T) {
+		result, err := liftover.LiftInterval(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 11, 30)
+		require.NoError(t, err)
+
+		assert.Equal(t, &liftover.IntervalResult{
+			Reference: types.ReferenceGRCh38,
+			Segments: []liftover.IntervalSegment{
+				{Chromosome: "2", Start: 11, End: 30, Strand: "+", ChainID: 1, Score: 100, Matched: 1},
+			},
+			Matched: 1,
+		}, result)
+	})
+
+	t.Run("Min Match", func(t *testing.T) {
+		_, err := liftover.LiftInterval(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 1, 100)
+		require.ErrorIs(t, err, liftover.ErrDeleted)
+
+		result, err := liftover.LiftInterval(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 1, 100, liftover.WithMinMatch(0.5))
+		require.NoError(t, err)
+
+		require.Len(t, result.Segments, 1)
+		assert.Equal(t, int64(1), result.Segments[0].Start)
+		assert.Equal(t, int64(110), result.Segments[0].End)
+		assert.InDelta(t, 0.9, result.Matched, 1e-9)
+	})
+
+	t.Run("Gap", func(t *testing.T) {
+		_, err := liftover.LiftInterval(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 41, 50)
+		require.ErrorIs(t, err, liftover.ErrDeleted)
+
+		_, err = liftover.Lift(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 45)
+		require.ErrorIs(t, err, liftover.ErrGap)
+	})
+
+	t.Run("Multiple", func(t *testing.T) {
+		_, err := liftover.LiftInterval(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 161, 170)
+		require.ErrorIs(t, err, liftover.ErrDuplicated)
+
+		result, err := liftover.LiftInterval(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "1", 161, 170, liftover.WithMultiple())
+		require.NoError(t, err)
+
+		assert.Equal(t, []liftover.IntervalSegment{
+			{Chromosome: "3", Start: 41, End: 50, Strand: "+", ChainID: 2, Score: 200, Matched: 1},
+			{Chromosome: "4", Start: 981, End: 990, Strand: "-", ChainID: 3, Score: 50, Matched: 1},
+		}, result.Segments)
+	})
+}
+
+func TestLiftVCF(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 100 + 0 50 1 60 + 10 60 1
+50
+
+chain 100 2 100 + 0 20 2 20 - 0 20 2
+20
+`))
+	require.NoError(t, err)
+
+	target := []fasta.Sequence{
+		{Description: "chr1", Values: []byte(strings.Repeat("ACGT", 15))},
+		{Description: "chr2", Values: []byte("AACCGGTTAACCGGTTAACC")},
 	}
 
-	snps := make(map[int64]snp)
-	for {
-		variant := vcfReader.Read()
-		if variant == nil {
-			break
-		}
+	vcf := `##fileformat=VCFv4.2
+##contig=<ID=chr1,length=100>
+##contig=<ID=chr2,length=100>
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO	FORMAT	SAMPLE
+chr1	5	rs1	G	A	.	PASS	.	GT	0/1
+chr1	6	rs2	C	T	.	PASS	AF=0.2	GT	0/0
+chr1	7	rs3	C	G	.	PASS	.	GT	0/1
+chr1	80	rs4	A	G	.	PASS	.	GT	0/1
+chr2	3	rs5	T	C	.	PASS	.	GT	0|1
+chr1	10	rs6	A	<DEL>	.	PASS	.	GT	0/1
+chr2	5	rs7	AA	A	.	PASS	.	GT	0/1
+`
 
-		idStr, err := variant.Info().Get("RS")
-		if err != nil {
-			continue
+	var lifted, rejected strings.Builder
+	stats, err := liftover.LiftVCF(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, target, strings.NewReader(vcf), &lifted, &rejected)
+	require.NoError(t, err)
+
+	assert.Equal(t, &liftover.VCFStats{
+		Lifted:  5,
+		Swapped: 1,
+		Rejected: map[string]int{
+			liftover.RejectMismatchedRefAllele: 1,
+			liftover.RejectNoTarget:            1,
+		},
+	}, stats)
+
+	var liftedRecords []string
+	for _, line := range strings.Split(lifted.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			liftedRecords = append(liftedRecords, line)
 		}
+	}
 
-		id, err := strconv.ParseInt(idStr.(string), 10, 64)
-		if err != nil {
-			continue
+	assert.Equal(t, []string{
+		"chr1\t15\trs1\tG\tA\t.\tPASS\tOriginalContig=chr1;OriginalStart=5\tGT\t0/1",
+		"chr1\t16\trs2\tT\tC\t.\tPASS\tAF=0.8;OriginalContig=chr1;OriginalStart=6;SwappedAlleles\tGT\t1/1",
+		"chr2\t18\trs5\tA\tG\t.\tPASS\tOriginalContig=chr2;OriginalStart=3;ReverseComplementedAlleles\tGT\t0|1",
+		"chr1\t20\trs6\tT\t<DEL>\t.\tPASS\tOriginalContig=chr1;OriginalStart=10\tGT\t0/1",
+		"chr2\t14\trs7\tGT\tG\t.\tPASS\tOriginalContig=chr2;OriginalStart=5;ReverseComplementedAlleles\tGT\t0/1",
+	}, liftedRecords)
+
+	var liftedContigs, rejectedContigs []string
+	for _, line := range strings.Split(lifted.String(), "\n") {
+		if strings.HasPrefix(line, "##contig") {
+			liftedContigs = append(liftedContigs, line)
 		}
+	}
 
-		snps[id] = snp{
-			id:         id,
-			chromosome: names.Chromosome(variant.Chromosome),
-			position:   int64(variant.Pos),
+	for _, line := range strings.Split(rejected.String(), "\n") {
+		if strings.HasPrefix(line, "##contig") {
+			rejectedContigs = append(rejectedContigs, line)
 		}
 	}
 
-	return snps, nil
+	assert.Equal(t, []string{
+		"##contig=<ID=chr1,length=60,assembly=GRCh38>",
+		"##contig=<ID=chr2,length=20,assembly=GRCh38>",
+	}, liftedContigs)
+	assert.Equal(t, []string{
+		"##contig=<ID=chr1,length=100>",
+		"##contig=<ID=chr2,length=100>",
+	}, rejectedContigs)
+
+	assert.Contains(t, rejected.String(), "chr1\t7\trs3\tC\tG\t.\tPASS\tLiftoverRejectReason=MismatchedRefAllele\tGT\t0/1")
+
+	t.Run("Contig Names", func(t *testing.T) {
+		vcf := `##fileformat=VCFv4.2
+##contig=<ID=1,length=100>
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO
+1	5	rs1	G	A	.	PASS	.
+`
+
+		var lifted strings.Builder
+		_, err := liftover.LiftVCF(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, target, strings.NewReader(vcf), &lifted, io.Discard)
+		require.NoError(t, err)
+
+		assert.Equal(t, `##fileformat=VCFv4.2
+##contig=<ID=1,length=60,assembly=GRCh38>
+##contig=<ID=2,length=20,assembly=GRCh38>
+`, lifted.String()[:strings.Index(lifted.String(), "##INFO")])
+		assert.Contains(t, lifted.String(), "\n1\t15\trs1\t")
+	})
+
+	t.Run("Source Failure", func(t *testing.T) {
+		_, err := liftover.LiftVCF(ctx, &failingSource{ChainSource: cf}, types.ReferenceGRCh37, types.ReferenceGRCh38,
+			target, strings.NewReader(vcf), io.Discard, io.Discard)
+		require.ErrorIs(t, err, errSourceFailed)
+	})
 }
 
-func readLegacySNPs(path string) (map[int64]snp, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func TestLiftVCFStructuralVariants(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 100 + 0 50 1 200 + 10 60 1
+50
+
+chain 100 2 100 + 0 20 2 20 - 0 20 2
+20
+
+chain 100 1 100 + 50 60 3 100 + 0 10 3
+10
+
+chain 100 1 100 + 60 100 1 200 + 100 160 4
+10 0 20
+30
+`))
+	require.NoError(t, err)
+
+	target := []fasta.Sequence{
+		{Description: "chr1", Values: []byte(strings.Repeat("ACGT", 50))},
+		{Description: "chr2", Values: []byte("AACCGGTTAACCGGTTAACC")},
+		{Description: "chr3", Values: []byte(strings.Repeat("ACGT", 5))},
 	}
 
-	dr, err := compress.Decompress(f)
-	if err != nil {
-		return nil, err
+	vcf := `##fileformat=VCFv4.2
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO
+chr1	5	sv1	N	<DEL>	.	PASS	SVTYPE=DEL;END=15;SVLEN=-10
+chr2	3	sv2	N	<DUP>	.	PASS	SVTYPE=DUP;END=8;SVLEN=5
+chr1	45	sv3	N	<DEL>	.	PASS	SVTYPE=DEL;END=55
+chr1	65	sv4	N	<DEL>	.	PASS	SVTYPE=DEL;END=75
+chr1	62	sv5	N	<INS>	.	PASS	SVTYPE=INS;SVLEN=100
+chr1	10	bnd1	A	A[chr2:5[	.	PASS	SVTYPE=BND
+chr2	3	bnd2	T	T[chr1:10[	.	PASS	SVTYPE=BND
+`
+
+	var lifted, rejected strings.Builder
+	stats, err := liftover.LiftVCF(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, target, strings.NewReader(vcf), &lifted, &rejected)
+	require.NoError(t, err)
+
+	assert.Equal(t, &liftover.VCFStats{
+		Lifted: 5,
+		Rejected: map[string]int{
+			liftover.RejectBreakpointsOnDifferentContigs: 1,
+			liftover.RejectSpanChanged:                   1,
+		},
+	}, stats)
+
+	var liftedRecords []string
+	for _, line := range strings.Split(lifted.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			liftedRecords = append(liftedRecords, line)
+		}
 	}
-	defer dr.Close()
 
-	tsvReader := csv.NewReader(dr)
-	tsvReader.Comma = '\t'
+	assert.Equal(t, []string{
+		"chr1\t15\tsv1\tG\t<DEL>\t.\tPASS\tSVTYPE=DEL;END=25;SVLEN=-10;OriginalContig=chr1;OriginalStart=5",
+		"chr2\t12\tsv2\tC\t<DUP>\t.\tPASS\tSVTYPE=DUP;END=17;SVLEN=5;OriginalContig=chr2;OriginalStart=3;ReverseComplementedAlleles",
+		"chr1\t102\tsv5\tC\t<INS>\t.\tPASS\tSVTYPE=INS;SVLEN=100;OriginalContig=chr1;OriginalStart=62",
+		"chr1\t20\tbnd1\tT\tT]chr2:16]\t.\tPASS\tSVTYPE=BND;OriginalContig=chr1;OriginalStart=10",
+		"chr2\t18\tbnd2\tA\t[chr1:20[A\t.\tPASS\tSVTYPE=BND;OriginalContig=chr2;OriginalStart=3;ReverseComplementedAlleles",
+	}, liftedRecords)
 
-	snps := make(map[int64]snp)
-	for {
-		record, err := tsvReader.Read()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
+	assert.Contains(t, rejected.String(), "chr1\t65\tsv4\tN\t<DEL>\t.\tPASS\tSVTYPE=DEL;END=75;LiftoverRejectReason=SpanChanged")
+
+	t.Run("Span Tolerance", func(t *testing.T) {
+		stats, err := liftover.LiftVCF(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, target, strings.NewReader(vcf), io.Discard, io.Discard,
+			liftover.WithSVSpanTolerance(2))
+		require.NoError(t, err)
+
+		assert.Equal(t, 6, stats.Lifted)
+	})
+}
+
+func TestLiftSumstats(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 100 + 0 50 1 60 + 10 60 1
+50
+
+chain 100 2 100 + 0 20 2 20 - 0 20 2
+20
+`))
+	require.NoError(t, err)
+
+	target := []fasta.Sequence{
+		{Description: "chr1", Values: []byte(strings.Repeat("ACGT", 15))},
+		{Description: "chr2", Values: []byte("AACCGGTTAACCGGTTAACC")},
+	}
+
+	t.Run("GWAS Catalog", func(t *testing.T) {
+		sumstats := `chromosome	base_pair_location	effect_allele	other_allele	beta	odds_ratio	effect_allele_frequency	p_value
+1	5	A	G	0.12	1.20	0.30	1e-8
+1	6	T	C	-0.05	0.95	0.70	0.01
+1	7	G	C	0.01	1.01	0.50	0.9
+2	3	C	T	0.2	1.22	0.1	0.5
+2	5	AT	A	0.3	1.35	0.2	0.1
+1	80	A	G	0.1	1.11	0.4	0.2
+1	NA	A	G	0.1	1.11	0.4	0.2
+`
+
+		var lifted, dropped strings.Builder
+		stats, err := liftover.LiftSumstats(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, strings.NewReader(sumstats), &lifted, &dropped,
+			liftover.WithSumstatsTarget(target))
+		require.NoError(t, err)
+
+		assert.Equal(t, &liftover.SumstatsStats{
+			Lifted:  3,
+			Flipped: 1,
+			Dropped: map[string]int{
+				liftover.DropMismatchedRefAllele: 1,
+				liftover.DropUnsupportedAlleles:  1,
+				liftover.ErrDeleted.Error():      1,
+				liftover.DropInvalidRow:          1,
+			},
+		}, stats)
+
+		assert.Equal(t, `chromosome	base_pair_location	effect_allele	other_allele	beta	odds_ratio	effect_allele_frequency	p_value
+1	15	A	G	0.12	1.20	0.30	1e-8
+1	16	C	T	0.05	1.05	0.30	0.01
+2	18	G	A	0.2	1.22	0.1	0.5
+`, lifted.String())
+
+		assert.Equal(t, `chromosome	base_pair_location	effect_allele	other_allele	beta	odds_ratio	effect_allele_frequency	p_value	drop_reason
+1	7	G	C	0.01	1.01	0.50	0.9	mismatched reference allele
+2	5	AT	A	0.3	1.35	0.2	0.1	unsupported alleles
+1	80	A	G	0.1	1.11	0.4	0.2	`+liftover.ErrDeleted.Error()+`
+1	NA	A	G	0.1	1.11	0.4	0.2	invalid row
+`, dropped.String())
+	})
+
+	t.Run("PLINK", func(t *testing.T) {
+		sumstats := ` CHR          SNP         BP   A1   A2       BETA
+   chr1          rs1          5    A    G       0.12
+   chr2          rs2          3    C    T      -0.2
+   chr1          rs3         80    A    G       0.1
+`
+
+		var lifted, dropped strings.Builder
+		stats, err := liftover.LiftSumstats(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, strings.NewReader(sumstats), &lifted, &dropped)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, stats.Lifted)
+		assert.Zero(t, stats.Flipped)
+
+		// Without the target sequences, alleles are only reverse complemented.
+		assert.Equal(t, ` CHR          SNP         BP   A1   A2       BETA
+chr1 rs1 15 A G 0.12
+chr2 rs2 18 G A -0.2
+`, lifted.String())
+
+		assert.Contains(t, dropped.String(), "chr1          rs3         80    A    G       0.1 "+strings.ReplaceAll(liftover.ErrDeleted.Error(), " ", "_"))
+	})
+
+	t.Run("Quoted CSV", func(t *testing.T) {
+		sumstats := `study,chr,pos,ea,nea,beta
+"Height, adult",1,5,A,G,0.12
+"BMI ""raw""",2,3,C,T,-0.2
+"Height, adult",1,"5
+`
+
+		var lifted, dropped strings.Builder
+		stats, err := liftover.LiftSumstats(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, strings.NewReader(sumstats), &lifted, &dropped)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, stats.Lifted)
+		assert.Equal(t, map[string]int{liftover.DropInvalidRow: 1}, stats.Dropped)
+
+		assert.Equal(t, `study,chr,pos,ea,nea,beta
+"Height, adult",1,15,A,G,0.12
+"BMI ""raw""",2,18,G,A,-0.2
+`, lifted.String())
+	})
+
+	t.Run("Missing Columns", func(t *testing.T) {
+		_, err := liftover.LiftSumstats(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, strings.NewReader("SNP,P\nrs1,0.1\n"), io.Discard, io.Discard)
+		require.Error(t, err)
+	})
+}
+
+func TestLiftHGVS(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("GRCh37 To GRCh38", func(t *testing.T) {
+		cf := readChainFile(t, "../testdata/GRCh37_to_GRCh38.chain.gz")
+
+		// BRCA1 c.5266dupC (rs80357906).
+		description, err := liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000017.10:g.41209079dup")
+		require.NoError(t, err)
+
+		assert.Equal(t, "NC_000017.11:g.43057062dup", description)
+
+		description, err = liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000017.10:g.41245466G>A")
+		require.NoError(t, err)
+
+		assert.Equal(t, "NC_000017.11:g.43093449G>A", description)
+
+		_, err = liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000017.11:g.43093449G>A")
+		assert.Error(t, err)
+	})
+
+	t.Run("Negative Strand", func(t *testing.T) {
+		cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 100 200 2 1000 - 300 400 1
+50 0 10
+50
+`))
+		require.NoError(t, err)
+
+		description, err := liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000001.10:g.110G>A")
+		require.NoError(t, err)
+
+		assert.Equal(t, "NC_000002.12:g.691C>T", description)
+
+		description, err = liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000001.10:g.110_111insAAC")
+		require.NoError(t, err)
+
+		assert.Equal(t, "NC_000002.12:g.690_691insGTT", description)
+
+		description, err = liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000001.10:g.110_112delGCA")
+		require.NoError(t, err)
+
+		assert.Equal(t, "NC_000002.12:g.689_691delTGC", description)
+
+		// The deletion spans the gap in the target.
+		_, err = liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000001.10:g.149_152del")
+		assert.ErrorIs(t, err, liftover.ErrSplit)
+	})
+
+	t.Run("Gaps In Both Assemblies", func(t *testing.T) {
+		// A base deleted from the source, and another inserted into the target at
+		// the same point, so the span of the variant is unchanged.
+		cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 100 201 2 1000 + 300 401 1
+50 1 1
+50
+`))
+		require.NoError(t, err)
+
+		_, err = liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000001.10:g.150_152del")
+		require.Error(t, err)
+		assert.NotNil(t, liftover.UnmappedReason(err))
+
+		description, err := liftover.LiftHGVS(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, "NC_000001.10:g.148_150del")
+		require.NoError(t, err)
+
+		assert.Equal(t, "NC_000002.12:g.348_350del", description)
+	})
+}
+
+func TestLiftBED(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 500 2 1000 + 100 600 1
+500
+
+chain 100 3 1000 + 0 100 4 1000 - 0 100 2
+100
+`))
+	require.NoError(t, err)
+
+	bed := `track name=test
+chr1	10	20	a	0	+
+chr1	10	20	b	0	+	12	18	0	2	3,4,	0,6,
+chr3	10	20	c	0	+	12	18	0	2	3,4,	0,6,
+chr1	600	700	d
+chr1	30
+chr1	15	15	e	0	+
+chr3	15	15	f	0	+
+`
+
+	var lifted, rejected bytes.Buffer
+	stats, err := liftover.LiftBED(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, strings.NewReader(bed), &lifted, &rejected,
+		liftover.WithCompression("lifted.bed.gz"))
+	require.NoError(t, err)
+
+	assert.Equal(t, &liftover.FeatureStats{
+		Lifted: 5,
+		Rejected: map[string]int{
+			liftover.ErrDeleted.Error():   1,
+			liftover.RejectInvalidFeature: 1,
+		},
+	}, stats)
+
+	assert.Equal(t, `track name=test
+chr2	110	120	a	0	+
+chr2	110	120	b	0	+	112	118	0	2	3,4,	0,6,
+chr4	980	990	c	0	-	982	988	0	2	4,3,	0,7,
+chr2	115	115	e	0	+
+chr4	985	985	f	0	-
+`, decompressString(t, &lifted))
+
+	assert.Equal(t, `track name=test
+#deleted in target
+chr1	600	700	d
+#invalid feature
+chr1	30
+`, decompressString(t, &rejected))
+}
+
+func TestLiftGFF(t *testing.T) {
+	ctx := context.Background()
+
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 500 2 1000 + 100 600 1
+500
+
+chain 100 3 1000 + 0 100 4 1000 - 0 100 2
+100
+`))
+	require.NoError(t, err)
+
+	t.Run("GFF3", func(t *testing.T) {
+		gff := `##gff-version 3
+##sequence-region 1 1 1000
+1	src	gene	11	20	.	+	.	ID=gene1
+1	src	mRNA	11	20	.	+	.	ID=tx1;Parent=gene1
+1	src	exon	11	13	.	+	.	Parent=tx1
+###
+1	src	gene	401	600	.	+	.	ID=gene2
+1	src	exon	401	450	.	+	.	Parent=gene2
+1	src	exon	590	600	.	+	.	Parent=gene2
+3	src	gene	11	20	.	-	.	ID=gene3
+1	src	exon	401	450	.	+	.	Parent=gene2
+1	src	gene	101	150	.	+	.	ID=gene4
+3	src	exon	11	15	.	-	.	Parent=gene3
+3	src	exon	21	30	.	-	.	Parent=gene4
+##FASTA
+>1
+ACGT
+`
+
+		var lifted, rejected strings.Builder
+		stats, err := liftover.LiftGFF(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, strings.NewReader(gff), &lifted, &rejected)
+		require.NoError(t, err)
+
+		assert.Equal(t, &liftover.FeatureStats{
+			Lifted: 6,
+			Rejected: map[string]int{
+				liftover.ErrDeleted.Error():           2,
+				liftover.ErrSplit.Error():             1,
+				liftover.RejectRelatedFeatureRejected: 2,
+			},
+		}, stats)
+
+		// Children that are not adjacent to their parents must be lifted to the
+		// same chromosome and strand.
+		assert.Equal(t, `##gff-version 3
+2	src	gene	111	120	.	+	.	ID=gene1
+2	src	mRNA	111	120	.	+	.	ID=tx1;Parent=gene1
+2	src	exon	111	113	.	+	.	Parent=tx1
+###
+4	src	gene	981	990	.	+	.	ID=gene3
+2	src	gene	201	250	.	+	.	ID=gene4
+4	src	exon	986	990	.	+	.	Parent=gene3
+`, lifted.String())
+
+		assert.Equal(t, `##gff-version 3
+#deleted in target
+1	src	gene	401	600	.	+	.	ID=gene2
+#related feature rejected
+1	src	exon	401	450	.	+	.	Parent=gene2
+#deleted in target
+1	src	exon	590	600	.	+	.	Parent=gene2
+#related feature rejected
+1	src	exon	401	450	.	+	.	Parent=gene2
+#split across chains
+3	src	exon	21	30	.	-	.	Parent=gene4
+`, rejected.String())
+	})
+
+	t.Run("GTF", func(t *testing.T) {
+		gtf := `chr1	src	transcript	11	20	.	+	.	gene_id "g1"; transcript_id "t1";
+chr1	src	exon	11	20	.	+	.	gene_id "g1"; transcript_id "t1";
+chr1	src	transcript	451	550	.	+	.	gene_id "g2"; transcript_id "t2";
+chr1	src	exon	451	470	.	+	.	gene_id "g2"; transcript_id "t2";
+`
+
+		var lifted, rejected strings.Builder
+		stats, err := liftover.LiftGFF(ctx, cf, types.ReferenceGRCh37, types.ReferenceGRCh38, strings.NewReader(gtf), &lifted, &rejected)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, stats.Lifted)
+		assert.Equal(t, `chr2	src	transcript	111	120	.	+	.	gene_id "g1"; transcript_id "t1";
+chr2	src	exon	111	120	.	+	.	gene_id "g1"; transcript_id "t1";
+`, lifted.String())
+
+		assert.Equal(t, `#deleted in target
+chr1	src	transcript	451	550	.	+	.	gene_id "g2"; transcript_id "t2";
+#related feature rejected
+chr1	src	exon	451	470	.	+	.	gene_id "g2"; transcript_id "t2";
+`, rejected.String())
+	})
+}
 
-			return nil, err
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+
+	grch37ToGRCh38 := readChainFile(t, "../testdata/GRCh37_to_GRCh38.chain.gz")
+
+	ncbi36ToGRCh38 := readChainFile(t, "../testdata/NCBI36_to_GRCh38.chain.gz")
+	ncbi36ToGRCh38.From = types.ReferenceNCBI36
+	ncbi36ToGRCh38.To = types.ReferenceGRCh38
+
+	grch38ToNCBI36, err := ncbi36ToGRCh38.Invert()
+	require.NoError(t, err)
+
+	router := liftover.NewRouter()
+	router.Register(types.ReferenceGRCh37, types.ReferenceGRCh38, grch37ToGRCh38)
+	require.NoError(t, router.RegisterSource(ctx, ncbi36ToGRCh38))
+	require.NoError(t, router.RegisterSource(ctx, grch38ToNCBI36))
+
+	// Chain files with unset assemblies don't report any pairs.
+	require.Error(t, router.RegisterSource(ctx, grch37ToGRCh38))
+
+	hops, err := router.Route(types.ReferenceGRCh37, types.ReferenceNCBI36)
+	require.NoError(t, err)
+
+	assert.Equal(t, []liftover.Hop{
+		{From: types.ReferenceGRCh37, To: types.ReferenceGRCh38},
+		{From: types.ReferenceGRCh38, To: types.ReferenceNCBI36},
+	}, hops)
+
+	_, err = router.Route(types.ReferenceGRCh38, types.ReferenceGRCh37)
+	require.Error(t, err)
+
+	grch37SNPs := readClinVarSNPs(t, "../testdata/clinvar_GRCh37_20231230.vcf.gz", types.ReferenceGRCh37)
+	ncbi36SNPs := readLegacySNPs(t, "../testdata/snp130.txt.gz")
+
+	var foundInBoth, successFullyLifted int
+	for id, locus := range grch37SNPs.Variants {
+		expected, ok := ncbi36SNPs.Variants[id]
+		if !ok {
+			continue
 		}
 
-		id, err := strconv.ParseInt(strings.TrimPrefix(record[4], "rs"), 10, 64)
+		foundInBoth++
+
+		result, err := router.Lift(ctx, types.ReferenceGRCh37, types.ReferenceNCBI36, locus.Chromosome, locus.Position)
 		if err != nil {
 			continue
 		}
 
-		position, err := strconv.ParseInt(record[2], 10, 64)
+		assert.Len(t, result.Hops, 2)
+		assert.Equal(t, types.ReferenceNCBI36, result.Reference)
+
+		if result.Chromosome == expected.Chromosome && result.Position == expected.Position {
+			successFullyLifted++
+		}
+	}
+
+	assert.Greater(t, successFullyLifted, 500)
+	assert.Greater(t, float64(successFullyLifted)/float64(foundInBoth), 0.85)
+}
+
+func readClinVarSNPs(t testing.TB, path string, reference types.Reference) *evaluate.TruthSet {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	ts, err := evaluate.ReadClinVar(f, reference)
+	require.NoError(t, err)
+
+	return ts
+}
+
+func readLegacySNPs(t testing.TB, path string) *evaluate.TruthSet {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	ts, err := evaluate.ReadDbSNP(f, types.ReferenceNCBI36)
+	require.NoError(t, err)
+
+	return ts
+}
+
+// requireBatchMatchesLift checks that lifting the SNPs as a batch gives the same
+// results as lifting them one at a time.
+func requireBatchMatchesLift(t *testing.T, src liftover.ChainSource, from, to types.Reference, snps *evaluate.TruthSet, opts ...liftover.LiftOption) {
+	ctx := context.Background()
+
+	// Map iteration order means the loci are not sorted.
+	var loci []liftover.Locus
+	for _, locus := range snps.Variants {
+		loci = append(loci, locus)
+	}
+
+	results, err := liftover.LiftBatch(ctx, src, from, to, loci, opts...)
+	require.NoError(t, err)
+	require.Len(t, results, len(loci))
+
+	for i, locus := range loci {
+		expected, err := liftover.Lift(ctx, src, from, to, locus.Chromosome, locus.Position, opts...)
 		if err != nil {
+			require.Error(t, results[i].Err, locus)
+			assert.Equal(t, liftover.UnmappedReason(err), liftover.UnmappedReason(results[i].Err), locus)
 			continue
 		}
 
-		snps[id] = snp{
-			id:         id,
-			chromosome: names.Chromosome(record[1]),
-			// The position is 0-based in the legacy file.
-			position: position + 1,
-		}
+		require.NoError(t, results[i].Err, locus)
+		assert.Equal(t, expected, results[i].Result, locus)
 	}
+}
+
+var errSourceFailed = errors.New("source failed")
+
+// failingSource is a chain source that fails to get chains (eg. a database
+// that has gone away).
+type failingSource struct {
+	liftover.ChainSource
+}
+
+func (s *failingSource) GetChain(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*types.Chain, error) {
+	return nil, errSourceFailed
+}
+
+func openDB(t *testing.T) *genobase.DB {
+	db, err := genobase.Open(context.Background(), slogt.New(t), filepath.Join(t.TempDir(), "genobase.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	return db
+}
+
+func decompressString(t *testing.T, r io.Reader) string {
+	dr, err := compress.Decompress(r)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, dr.Close())
+	})
+
+	data, err := io.ReadAll(dr)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func readChainFile(t testing.TB, path string) *chainfile.ChainFile {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, f.Close())
+	})
+
+	dr, err := compress.Decompress(f)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, dr.Close())
+	})
+
+	cf, err := chainfile.Read(dr)
+	require.NoError(t, err)
 
-	return snps, nil
+	return cf
 }
@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zymatik-com/genobase/types"
+)
+
+// RoundTripResult is the result of lifting a position to another reference
+// genome assembly and back again.
+type RoundTripResult struct {
+	Forward    *LiftResult // Position in the target assembly, nil if it could not be lifted.
+	Reverse    *LiftResult // Position lifted back to the source assembly, nil if it could not be lifted.
+	Consistent bool        // Whether the position was lifted back to where it started.
+	Err        error       // Reason the position could not be lifted in either direction.
+}
+
+// RoundTripSummary summarizes the round trip consistency of a batch of positions.
+type RoundTripSummary struct {
+	Total           int // Number of positions checked.
+	Consistent      int // Positions lifted back to where they started.
+	Inconsistent    int // Positions lifted back to a different position.
+	Unmapped        int // Positions that could not be lifted to the target assembly.
+	ReverseUnmapped int // Lifted positions that could not be lifted back.
+}
+
+// RoundTrip lifts a position from one reference genome assembly to another,
+// and back again using the reverse source (eg. an inverted chain file, or a
+// source that has chains for both directions), reporting whether it returns
+// to the same position. Positions that do not are unstable, and are best
+// excluded from downstream analysis. Positions are lifted in both directions
+// using the given options. An error is returned if the position can not be
+// lifted to the target assembly.
+func RoundTrip(ctx context.Context, src, reverse ChainSource, from, to types.Reference, chromosome types.Chromosome, position int64, opts ...LiftOption) (*RoundTripResult, error) {
+	forward, err := Lift(ctx, src, from, to, chromosome, position, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RoundTripResult{
+		Forward: forward,
+	}
+
+	result.Reverse, err = Lift(ctx, reverse, to, from, forward.Chromosome, forward.Position, opts...)
+	if err != nil {
+		if UnmappedReason(err) == nil {
+			return nil, err
+		}
+
+		result.Err = fmt.Errorf("could not lift back to %s: %w", from, err)
+		return result, nil
+	}
+
+	result.Consistent = result.Reverse.Chromosome == chromosome && result.Reverse.Position == position
+
+	return result, nil
+}
+
+// RoundTripBatch checks the round trip consistency of many positions at once
+// (see RoundTrip and LiftBatch), returning a result for each in the same order
+// as the input, and a summary of the batch.
+func RoundTripBatch(ctx context.Context, src, reverse ChainSource, from, to types.Reference, loci []Locus, opts ...LiftOption) ([]RoundTripResult, *RoundTripSummary, error) {
+	forward, err := LiftBatch(ctx, src, from, to, loci, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The indices of the lifted positions.
+	var lifted []int
+	var liftedLoci []Locus
+	for i, result := range forward {
+		if result.Result != nil {
+			lifted = append(lifted, i)
+			liftedLoci = append(liftedLoci, Locus{Chromosome: result.Result.Chromosome, Position: result.Result.Position})
+		}
+	}
+
+	reverseResults, err := LiftBatch(ctx, reverse, to, from, liftedLoci, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]RoundTripResult, len(loci))
+	for i, result := range forward {
+		results[i] = RoundTripResult{
+			Forward: result.Result,
+			Err:     result.Err,
+		}
+	}
+
+	for j, i := range lifted {
+		results[i].Reverse = reverseResults[j].Result
+		if reverseResults[j].Err != nil {
+			results[i].Err = fmt.Errorf("could not lift back to %s: %w", from, reverseResults[j].Err)
+			continue
+		}
+
+		results[i].Consistent = results[i].Reverse.Chromosome == loci[i].Chromosome && results[i].Reverse.Position == loci[i].Position
+	}
+
+	summary := &RoundTripSummary{
+		Total: len(loci),
+	}
+
+	for _, result := range results {
+		switch {
+		case result.Forward == nil:
+			summary.Unmapped++
+		case result.Reverse == nil:
+			summary.ReverseUnmapped++
+		case result.Consistent:
+			summary.Consistent++
+		default:
+			summary.Inconsistent++
+		}
+	}
+
+	return results, summary, nil
+}
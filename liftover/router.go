@@ -0,0 +1,184 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/liftover/chainfile"
+)
+
+// Hop is a single step in a liftover route, from one reference genome assembly
+// to another.
+type Hop = chainfile.Pair
+
+// RoutedLiftResult is the result of lifting a position through one or more
+// chain sources.
+type RoutedLiftResult struct {
+	LiftResult
+	// Hops contains the result of each step of the route, in order.
+	Hops []LiftResult
+}
+
+// Router lifts positions between any two reference genome assemblies that are
+// connected by a path of registered chain sources (eg. NCBI36 to GRCh37 via
+// GRCh38). It is safe for concurrent use.
+type Router struct {
+	mu      sync.RWMutex
+	sources map[Hop]ChainSource
+}
+
+// NewRouter creates a new, empty, liftover router.
+func NewRouter() *Router {
+	return &Router{
+		sources: make(map[Hop]ChainSource),
+	}
+}
+
+// Register adds a chain source that lifts from one reference genome assembly
+// to another. Sources only lift in one direction, so to route in reverse, an
+// inverted chain file (see chainfile.ChainFile.Invert) must also be registered.
+func (r *Router) Register(from, to types.Reference, src ChainSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sources[Hop{From: from, To: to}] = src
+}
+
+// RegisterSource adds a chain source for every (from, to) pair it reports it
+// can lift between.
+func (r *Router) RegisterSource(ctx context.Context, src ChainSource) error {
+	pairs, err := src.Pairs(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get chain source pairs: %w", err)
+	}
+
+	if len(pairs) == 0 {
+		return fmt.Errorf("chain source does not report any pairs")
+	}
+
+	for _, pair := range pairs {
+		r.Register(pair.From, pair.To, src)
+	}
+
+	return nil
+}
+
+// Route returns the shortest sequence of hops from one reference genome
+// assembly to another.
+func (r *Router) Route(from, to types.Reference) ([]Hop, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.route(from, to)
+}
+
+func (r *Router) route(from, to types.Reference) ([]Hop, error) {
+	if from == to {
+		return nil, fmt.Errorf("source and target assemblies are both %s", from)
+	}
+
+	adjacent := make(map[types.Reference][]types.Reference)
+	for hop := range r.sources {
+		adjacent[hop.From] = append(adjacent[hop.From], hop.To)
+	}
+
+	// Sort the neighbours so routes are deterministic.
+	for _, neighbours := range adjacent {
+		sort.Slice(neighbours, func(i, j int) bool {
+			return neighbours[i] < neighbours[j]
+		})
+	}
+
+	// Breadth first search for the route with the fewest hops.
+	previous := map[types.Reference]types.Reference{from: ""}
+	queue := []types.Reference{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if current == to {
+			var hops []Hop
+			for ref := to; ref != from; ref = previous[ref] {
+				hops = append([]Hop{{From: previous[ref], To: ref}}, hops...)
+			}
+
+			return hops, nil
+		}
+
+		for _, next := range adjacent[current] {
+			if _, visited := previous[next]; !visited {
+				previous[next] = current
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no route from %s to %s", from, to)
+}
+
+// Lift lifts the given position from one reference genome assembly to another,
+// via any intermediate assemblies required. The strand of the result is relative
+// to the source assembly.
+func (r *Router) Lift(ctx context.Context, from, to types.Reference, chromosome types.Chromosome, position int64) (*RoutedLiftResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	hops, err := r.route(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	routed := &RoutedLiftResult{
+		LiftResult: LiftResult{
+			Chromosome: chromosome,
+			Position:   position,
+			Strand:     "+",
+		},
+	}
+
+	for _, hop := range hops {
+		result, err := Lift(ctx, r.sources[hop], hop.From, hop.To, routed.Chromosome, routed.Position)
+		if err != nil {
+			return nil, fmt.Errorf("could not lift from %s to %s: %w", hop.From, hop.To, err)
+		}
+
+		strand := routed.Strand
+		if result.Strand == "-" {
+			strand = flipStrand(strand)
+		}
+
+		routed.LiftResult = *result
+		routed.Strand = strand
+		routed.Hops = append(routed.Hops, *result)
+	}
+
+	return routed, nil
+}
+
+// flipStrand flips a strand ('+' or '-'), leaving any other value (eg. the '.'
+// of an unstranded feature) alone.
+func flipStrand(strand string) string {
+	switch strand {
+	case "+":
+		return "-"
+	case "-":
+		return "+"
+	default:
+		return strand
+	}
+}
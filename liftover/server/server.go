@@ -0,0 +1,543 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+// Package server provides a HTTP liftover service, with JSON endpoints for
+// lifting single positions, batches of positions, and intervals, backed by any
+// liftover.ChainSource (eg. a chain file, an index, or a genobase database).
+//
+// The endpoints are:
+//
+//	GET  /pairs           The (from, to) assembly pairs the source can lift between.
+//	POST /lift            Lift a single position (LiftRequest).
+//	POST /lift/batch      Lift a batch of positions (BatchRequest).
+//	POST /lift/interval   Lift an interval (IntervalRequest).
+//
+// Errors are returned as an ErrorResponse, with a 404 Not Found status if the
+// position or interval could not be lifted.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/liftover"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+const (
+	// DefaultMaxBatchSize is the default maximum number of positions in a batch.
+	DefaultMaxBatchSize = 100000
+	// maxRequestBytes is the maximum size of a request body.
+	maxRequestBytes = 64 << 20
+)
+
+// Pair is a (from, to) pair of reference genome assemblies.
+type Pair struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PairsResponse is the response of the pairs endpoint.
+type PairsResponse struct {
+	// Pairs are the assembly pairs the source can lift between, empty if the
+	// source will lift between any pair it is asked to.
+	Pairs []Pair `json:"pairs"`
+}
+
+// LiftRequest is a request to lift a single position.
+type LiftRequest struct {
+	From       string `json:"from"`              // Source assembly (eg. "GRCh37" or "hg19").
+	To         string `json:"to"`                // Target assembly (eg. "GRCh38" or "hg38").
+	Chromosome string `json:"chromosome"`        // Chromosome (eg. "chr1" or "1").
+	Position   int64  `json:"position"`          // Position (1-based).
+	Nearest    bool   `json:"nearest,omitempty"` // Lift the nearest aligned position if the position falls in a gap.
+}
+
+// LiftResponse is a lifted position.
+type LiftResponse struct {
+	Reference   string `json:"reference"`
+	Chromosome  string `json:"chromosome"`
+	Position    int64  `json:"position"`
+	Strand      string `json:"strand"`
+	ChainID     int64  `json:"chain_id"`
+	Score       int64  `json:"score"`
+	Approximate bool   `json:"approximate,omitempty"`
+	Distance    int64  `json:"distance,omitempty"`
+}
+
+// Locus is a position in a batch.
+type Locus struct {
+	Chromosome string `json:"chromosome"`
+	Position   int64  `json:"position"`
+}
+
+// BatchRequest is a request to lift a batch of positions.
+type BatchRequest struct {
+	From    string  `json:"from"`
+	To      string  `json:"to"`
+	Loci    []Locus `json:"loci"`
+	Nearest bool    `json:"nearest,omitempty"`
+}
+
+// BatchResult is the result of lifting a position in a batch, either the
+// lifted position or the reason it could not be lifted.
+type BatchResult struct {
+	Result *LiftResponse `json:"result,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Reason string        `json:"reason,omitempty"` // See ErrorResponse.
+}
+
+// BatchResponse is the response to a batch request, with a result for every
+// position in the same order as the request.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// IntervalRequest is a request to lift an interval.
+type IntervalRequest struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Chromosome string `json:"chromosome"`
+	Start      int64  `json:"start"` // Start position (1-based, inclusive).
+	End        int64  `json:"end"`   // End position (1-based, inclusive).
+	// MinMatch is the minimum fraction of bases that must remap, defaults to
+	// liftover.DefaultMinMatch.
+	MinMatch *float64 `json:"min_match,omitempty"`
+	// Multiple allows the interval to be lifted to multiple target regions.
+	Multiple bool `json:"multiple,omitempty"`
+}
+
+// IntervalSegment is a target region an interval was lifted to.
+type IntervalSegment struct {
+	Chromosome string  `json:"chromosome"`
+	Start      int64   `json:"start"`
+	End        int64   `json:"end"`
+	Strand     string  `json:"strand"`
+	ChainID    int64   `json:"chain_id"`
+	Score      int64   `json:"score"`
+	Matched    float64 `json:"matched"`
+}
+
+// IntervalResponse is a lifted interval.
+type IntervalResponse struct {
+	Reference string            `json:"reference"`
+	Segments  []IntervalSegment `json:"segments"`
+	Matched   float64           `json:"matched"`
+}
+
+// ErrorResponse is returned when a request fails.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	// Reason is the reason a position or interval could not be lifted (eg.
+	// "deleted in target"), see liftover.UnmappedReason.
+	Reason string `json:"reason,omitempty"`
+}
+
+type options struct {
+	maxBatchSize int
+	workers      int
+}
+
+// Option configures the behavior of the handler.
+type Option func(*options)
+
+// WithMaxBatchSize sets the maximum number of positions in a batch request.
+func WithMaxBatchSize(n int) Option {
+	return func(opts *options) {
+		opts.maxBatchSize = n
+	}
+}
+
+// WithWorkers sets the number of chromosomes lifted concurrently for each
+// batch request (see liftover.WithWorkers).
+func WithWorkers(n int) Option {
+	return func(opts *options) {
+		opts.workers = n
+	}
+}
+
+// Handler is a http.Handler serving liftover requests. It is safe for
+// concurrent use, if the chain source is.
+type Handler struct {
+	src     liftover.ChainSource
+	options options
+	mux     *http.ServeMux
+
+	// pairs are the assembly pairs of the source, loaded on first use (see
+	// Refresh).
+	mu     sync.Mutex
+	pairs  []liftover.Hop
+	loaded bool
+}
+
+// NewHandler creates a new liftover handler backed by the given chain source.
+// The assembly pairs of the source are loaded on the first request, and are
+// not reloaded unless the handler is refreshed (see Refresh).
+func NewHandler(src liftover.ChainSource, opts ...Option) *Handler {
+	h := &Handler{
+		src: src,
+		options: options{
+			maxBatchSize: DefaultMaxBatchSize,
+		},
+		mux: http.NewServeMux(),
+	}
+
+	for _, opt := range opts {
+		opt(&h.options)
+	}
+
+	h.mux.HandleFunc("/pairs", h.method(http.MethodGet, h.listPairs))
+	h.mux.HandleFunc("/lift", h.method(http.MethodPost, h.lift))
+	h.mux.HandleFunc("/lift/batch", h.method(http.MethodPost, h.batch))
+	h.mux.HandleFunc("/lift/interval", h.method(http.MethodPost, h.interval))
+
+	return h
+}
+
+// Refresh reloads the assembly pairs of the chain source, which are otherwise
+// loaded once, on the first request (eg. after storing another chain file in
+// a database).
+func (h *Handler) Refresh(ctx context.Context) error {
+	pairs, err := h.src.Pairs(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get assembly pairs: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pairs, h.loaded = pairs, true
+
+	return nil
+}
+
+// ServeHTTP serves a liftover request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) method(method string, handler func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, &ErrorResponse{Error: "method not allowed"})
+			return
+		}
+
+		if err := handler(w, r); err != nil {
+			writeError(w, err)
+		}
+	}
+}
+
+func (h *Handler) listPairs(w http.ResponseWriter, r *http.Request) error {
+	pairs, err := h.sourcePairs(r.Context())
+	if err != nil {
+		return err
+	}
+
+	resp := &PairsResponse{
+		Pairs: make([]Pair, 0, len(pairs)),
+	}
+	for _, pair := range pairs {
+		resp.Pairs = append(resp.Pairs, Pair{From: string(pair.From), To: string(pair.To)})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+func (h *Handler) lift(w http.ResponseWriter, r *http.Request) error {
+	var req LiftRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		return err
+	}
+
+	from, to, err := h.assemblies(r.Context(), req.From, req.To)
+	if err != nil {
+		return err
+	}
+
+	chromosome, err := parseChromosome(req.Chromosome)
+	if err != nil {
+		return err
+	}
+
+	if req.Position < 1 {
+		return badRequest("invalid position %d", req.Position)
+	}
+
+	var opts []liftover.LiftOption
+	if req.Nearest {
+		opts = append(opts, liftover.WithNearest())
+	}
+
+	result, err := liftover.Lift(r.Context(), h.src, from, to, chromosome, req.Position, opts...)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, liftResponse(result))
+	return nil
+}
+
+func (h *Handler) batch(w http.ResponseWriter, r *http.Request) error {
+	var req BatchRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		return err
+	}
+
+	from, to, err := h.assemblies(r.Context(), req.From, req.To)
+	if err != nil {
+		return err
+	}
+
+	if len(req.Loci) > h.options.maxBatchSize {
+		return badRequest("too many positions in batch (%d > %d)", len(req.Loci), h.options.maxBatchSize)
+	}
+
+	loci := make([]liftover.Locus, len(req.Loci))
+	for i, locus := range req.Loci {
+		chromosome, err := parseChromosome(locus.Chromosome)
+		if err != nil {
+			return badRequest("invalid locus %d: %s", i, err)
+		}
+
+		if locus.Position < 1 {
+			return badRequest("invalid locus %d: invalid position %d", i, locus.Position)
+		}
+
+		loci[i] = liftover.Locus{Chromosome: chromosome, Position: locus.Position}
+	}
+
+	var opts []liftover.LiftOption
+	if req.Nearest {
+		opts = append(opts, liftover.WithNearest())
+	}
+	if h.options.workers > 0 {
+		opts = append(opts, liftover.WithWorkers(h.options.workers))
+	}
+
+	results, err := liftover.LiftBatch(r.Context(), h.src, from, to, loci, opts...)
+	if err != nil {
+		return err
+	}
+
+	resp := &BatchResponse{
+		Results: make([]BatchResult, len(results)),
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			resp.Results[i].Error = result.Err.Error()
+			if reason := liftover.UnmappedReason(result.Err); reason != nil {
+				resp.Results[i].Reason = reason.Error()
+			}
+
+			continue
+		}
+
+		resp.Results[i].Result = liftResponse(result.Result)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+func (h *Handler) interval(w http.ResponseWriter, r *http.Request) error {
+	var req IntervalRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		return err
+	}
+
+	from, to, err := h.assemblies(r.Context(), req.From, req.To)
+	if err != nil {
+		return err
+	}
+
+	chromosome, err := parseChromosome(req.Chromosome)
+	if err != nil {
+		return err
+	}
+
+	if req.Start < 1 || req.End < req.Start {
+		return badRequest("invalid interval %d-%d", req.Start, req.End)
+	}
+
+	var opts []liftover.IntervalOption
+	if req.MinMatch != nil {
+		if *req.MinMatch <= 0 || *req.MinMatch > 1 {
+			return badRequest("invalid min_match %g", *req.MinMatch)
+		}
+
+		opts = append(opts, liftover.WithMinMatch(*req.MinMatch))
+	}
+	if req.Multiple {
+		opts = append(opts, liftover.WithMultiple())
+	}
+
+	result, err := liftover.LiftInterval(r.Context(), h.src, from, to, chromosome, req.Start, req.End, opts...)
+	if err != nil {
+		return err
+	}
+
+	resp := &IntervalResponse{
+		Reference: string(result.Reference),
+		Segments:  make([]IntervalSegment, len(result.Segments)),
+		Matched:   result.Matched,
+	}
+	for i, segment := range result.Segments {
+		resp.Segments[i] = IntervalSegment{
+			Chromosome: string(segment.Chromosome),
+			Start:      segment.Start,
+			End:        segment.End,
+			Strand:     segment.Strand,
+			ChainID:    segment.ChainID,
+			Score:      segment.Score,
+			Matched:    segment.Matched,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+// sourcePairs returns the assembly pairs of the source, loading them if they
+// have not been loaded yet.
+func (h *Handler) sourcePairs(ctx context.Context) ([]liftover.Hop, error) {
+	h.mu.Lock()
+	pairs, loaded := h.pairs, h.loaded
+	h.mu.Unlock()
+
+	if loaded {
+		return pairs, nil
+	}
+
+	if err := h.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.pairs, nil
+}
+
+// assemblies parses the requested assemblies, and checks the source can lift
+// between them.
+func (h *Handler) assemblies(ctx context.Context, fromName, toName string) (types.Reference, types.Reference, error) {
+	from, err := names.Reference(fromName)
+	if err != nil {
+		return "", "", badRequest("invalid source assembly %q", fromName)
+	}
+
+	to, err := names.Reference(toName)
+	if err != nil {
+		return "", "", badRequest("invalid target assembly %q", toName)
+	}
+
+	pairs, err := h.sourcePairs(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Sources that do not know their assemblies lift between any pair.
+	if len(pairs) == 0 {
+		return from, to, nil
+	}
+
+	for _, pair := range pairs {
+		if pair.From == from && pair.To == to {
+			return from, to, nil
+		}
+	}
+
+	return "", "", badRequest("unsupported assembly pair %s to %s", from, to)
+}
+
+func parseChromosome(chromosome string) (types.Chromosome, error) {
+	if chromosome == "" {
+		return "", badRequest("missing chromosome")
+	}
+
+	return names.Chromosome(chromosome), nil
+}
+
+func liftResponse(result *liftover.LiftResult) *LiftResponse {
+	return &LiftResponse{
+		Reference:   string(result.Reference),
+		Chromosome:  string(result.Chromosome),
+		Position:    result.Position,
+		Strand:      result.Strand,
+		ChainID:     result.ChainID,
+		Score:       result.Score,
+		Approximate: result.Approximate,
+		Distance:    result.Distance,
+	}
+}
+
+// requestError is an error caused by an invalid request.
+type requestError struct {
+	msg string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...any) error {
+	return &requestError{msg: fmt.Sprintf(format, args...)}
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, req any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(req); err != nil {
+		return badRequest("invalid request: %s", err)
+	}
+
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if reason := liftover.UnmappedReason(err); reason != nil {
+		writeJSON(w, http.StatusNotFound, &ErrorResponse{Error: err.Error(), Reason: reason.Error()})
+		return
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		writeJSON(w, http.StatusServiceUnavailable, &ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// The status has already been written, so there is nothing more to do if
+	// the client has gone away.
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,222 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/liftover"
+	"github.com/zymatik-com/nucleo/liftover/chainfile"
+	"github.com/zymatik-com/nucleo/liftover/server"
+)
+
+func TestHandler(t *testing.T) {
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 500 2 1000 + 100 600 1
+500
+`))
+	require.NoError(t, err)
+
+	cf.From, cf.To = types.ReferenceGRCh37, types.ReferenceGRCh38
+
+	srv := httptest.NewServer(server.NewHandler(cf, server.WithMaxBatchSize(3)))
+	t.Cleanup(srv.Close)
+
+	t.Run("Pairs", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/pairs")
+		require.NoError(t, err)
+
+		var pairs server.PairsResponse
+		requireResponse(t, resp, http.StatusOK, &pairs)
+
+		assert.Equal(t, []server.Pair{{From: "GRCh37", To: "GRCh38"}}, pairs.Pairs)
+	})
+
+	t.Run("Lift", func(t *testing.T) {
+		var result server.LiftResponse
+		requireResponse(t, post(t, srv.URL+"/lift", server.LiftRequest{
+			From:       "hg19",
+			To:         "hg38",
+			Chromosome: "chr1",
+			Position:   10,
+		}), http.StatusOK, &result)
+
+		assert.Equal(t, server.LiftResponse{
+			Reference:  "GRCh38",
+			Chromosome: "2",
+			Position:   110,
+			Strand:     "+",
+			ChainID:    1,
+			Score:      100,
+		}, result)
+
+		var errResp server.ErrorResponse
+		requireResponse(t, post(t, srv.URL+"/lift", server.LiftRequest{
+			From:       "GRCh37",
+			To:         "GRCh38",
+			Chromosome: "1",
+			Position:   700,
+		}), http.StatusNotFound, &errResp)
+
+		assert.NotEmpty(t, errResp.Reason)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		var results server.BatchResponse
+		requireResponse(t, post(t, srv.URL+"/lift/batch", server.BatchRequest{
+			From: "GRCh37",
+			To:   "GRCh38",
+			Loci: []server.Locus{
+				{Chromosome: "1", Position: 20},
+				{Chromosome: "1", Position: 700},
+				{Chromosome: "chr1", Position: 10},
+			},
+		}), http.StatusOK, &results)
+
+		require.Len(t, results.Results, 3)
+
+		assert.Equal(t, int64(120), results.Results[0].Result.Position)
+		assert.Nil(t, results.Results[1].Result)
+		assert.NotEmpty(t, results.Results[1].Error)
+		assert.NotEmpty(t, results.Results[1].Reason)
+		assert.Equal(t, int64(110), results.Results[2].Result.Position)
+	})
+
+	t.Run("Interval", func(t *testing.T) {
+		var result server.IntervalResponse
+		requireResponse(t, post(t, srv.URL+"/lift/interval", server.IntervalRequest{
+			From:       "GRCh37",
+			To:         "GRCh38",
+			Chromosome: "1",
+			Start:      10,
+			End:        20,
+		}), http.StatusOK, &result)
+
+		assert.Equal(t, "GRCh38", result.Reference)
+		require.Len(t, result.Segments, 1)
+		assert.Equal(t, server.IntervalSegment{
+			Chromosome: "2",
+			Start:      110,
+			End:        120,
+			Strand:     "+",
+			ChainID:    1,
+			Score:      100,
+			Matched:    1,
+		}, result.Segments[0])
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		minMatch := 2.0
+
+		for name, tc := range map[string]struct {
+			path string
+			body any
+		}{
+			"Unknown Assembly":         {"/lift", server.LiftRequest{From: "hg20", To: "GRCh38", Chromosome: "1", Position: 10}},
+			"Unsupported Pair":         {"/lift", server.LiftRequest{From: "GRCh38", To: "GRCh37", Chromosome: "1", Position: 10}},
+			"Missing Chromosome":       {"/lift", server.LiftRequest{From: "GRCh37", To: "GRCh38", Position: 10}},
+			"Invalid Position":         {"/lift", server.LiftRequest{From: "GRCh37", To: "GRCh38", Chromosome: "1"}},
+			"Unknown Field":            {"/lift", map[string]any{"from": "GRCh37", "to": "GRCh38", "chromosome": "1", "position": 10, "strand": "+"}},
+			"Malformed":                {"/lift", "not a request"},
+			"Too Many Positions":       {"/lift/batch", server.BatchRequest{From: "GRCh37", To: "GRCh38", Loci: make([]server.Locus, 4)}},
+			"Invalid Locus":            {"/lift/batch", server.BatchRequest{From: "GRCh37", To: "GRCh38", Loci: []server.Locus{{Chromosome: "1"}}}},
+			"Invalid Interval":         {"/lift/interval", server.IntervalRequest{From: "GRCh37", To: "GRCh38", Chromosome: "1", Start: 20, End: 10}},
+			"Invalid Minimum Matching": {"/lift/interval", server.IntervalRequest{From: "GRCh37", To: "GRCh38", Chromosome: "1", Start: 10, End: 20, MinMatch: &minMatch}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				var errResp server.ErrorResponse
+				requireResponse(t, post(t, srv.URL+tc.path, tc.body), http.StatusBadRequest, &errResp)
+
+				assert.NotEmpty(t, errResp.Error)
+			})
+		}
+
+		resp, err := http.Get(srv.URL + "/lift")
+		require.NoError(t, err)
+
+		var errResp server.ErrorResponse
+		requireResponse(t, resp, http.StatusMethodNotAllowed, &errResp)
+	})
+}
+
+func TestHandlerRefresh(t *testing.T) {
+	cf, err := chainfile.Read(strings.NewReader(`chain 100 1 1000 + 0 500 2 1000 + 100 600 1
+500
+`))
+	require.NoError(t, err)
+
+	cf.From, cf.To = types.ReferenceGRCh37, types.ReferenceGRCh38
+
+	src := &countingSource{ChainSource: cf}
+
+	handler := server.NewHandler(src)
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	req := server.LiftRequest{From: "GRCh37", To: "GRCh38", Chromosome: "1", Position: 10}
+	for i := 0; i < 3; i++ {
+		var result server.LiftResponse
+		requireResponse(t, post(t, srv.URL+"/lift", req), http.StatusOK, &result)
+	}
+
+	// The pairs are only loaded once.
+	assert.Equal(t, 1, src.calls)
+
+	// Until the handler is refreshed.
+	cf.To = types.ReferenceTelomereToTelomereV2
+	require.NoError(t, handler.Refresh(context.Background()))
+	assert.Equal(t, 2, src.calls)
+
+	var errResp server.ErrorResponse
+	requireResponse(t, post(t, srv.URL+"/lift", req), http.StatusBadRequest, &errResp)
+}
+
+// countingSource counts the calls to Pairs.
+type countingSource struct {
+	liftover.ChainSource
+	calls int
+}
+
+func (s *countingSource) Pairs(ctx context.Context) ([]liftover.Hop, error) {
+	s.calls++
+	return s.ChainSource.Pairs(ctx)
+}
+
+func post(t *testing.T, url string, body any) *http.Response {
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(body))
+
+	resp, err := http.Post(url, "application/json", &buf)
+	require.NoError(t, err)
+
+	return resp
+}
+
+func requireResponse(t *testing.T, resp *http.Response, status int, v any) {
+	t.Helper()
+
+	defer resp.Body.Close()
+
+	require.Equal(t, status, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
@@ -0,0 +1,462 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/compress"
+	"github.com/zymatik-com/nucleo/fasta"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+// Reasons a summary statistics row could not be lifted (besides the unmapped
+// reasons, eg. ErrDeleted), recorded in the report of dropped rows.
+const (
+	// DropInvalidRow indicates the row could not be parsed.
+	DropInvalidRow = "invalid row"
+	// DropUnsupportedAlleles indicates the alleles of the row could not be
+	// reverse complemented (eg. indels lifted onto the negative strand).
+	DropUnsupportedAlleles = "unsupported alleles"
+	// DropMismatchedRefAllele indicates the target reference base matches
+	// neither allele of the row.
+	DropMismatchedRefAllele = "mismatched reference allele"
+	// DropNoTargetSequence indicates the target FASTA does not contain the
+	// chromosome the row was lifted to.
+	DropNoTargetSequence = "no target sequence"
+)
+
+// SumstatsReasonColumn is the column appended to the report of dropped rows,
+// containing the reason each row was dropped.
+const SumstatsReasonColumn = "drop_reason"
+
+// Common names of summary statistics columns, normalized to lower case
+// without punctuation (eg. "base_pair_location" is "basepairlocation").
+var (
+	sumstatsChromosomeColumns   = []string{"chr", "chrom", "chromosome", "chrname", "hg19chrom", "hg38chrom"}
+	sumstatsPositionColumns     = []string{"pos", "bp", "position", "basepairlocation", "bpos", "chrpos"}
+	sumstatsEffectAlleleColumns = []string{"ea", "a1", "effectallele", "allele1", "alt", "testedallele", "riskallele"}
+	sumstatsOtherAlleleColumns  = []string{"nea", "a2", "otherallele", "noneffectallele", "allele2", "ref", "referenceallele"}
+	sumstatsBetaColumns         = []string{"beta", "b", "effect", "effectsize", "logor"}
+	sumstatsZColumns            = []string{"z", "zscore", "zstat"}
+	sumstatsOddsRatioColumns    = []string{"or", "oddsratio"}
+	sumstatsFrequencyColumns    = []string{"eaf", "effectallelefrequency", "freq", "frq", "freq1", "af", "a1freq"}
+)
+
+// SumstatsStats summarizes the result of lifting a summary statistics file.
+type SumstatsStats struct {
+	Lifted  int            // Number of rows lifted to the target genome.
+	Flipped int            // Number of lifted rows whose alleles were swapped, and effects flipped.
+	Dropped map[string]int // Number of rows dropped, by reason.
+}
+
+type sumstatsOptions struct {
+	target []fasta.Sequence
+}
+
+// SumstatsOption configures the behavior of LiftSumstats.
+type SumstatsOption func(*sumstatsOptions)
+
+// WithSumstatsTarget checks the alleles of lifted single nucleotide variants
+// against the target genome sequences, so that the other (non-effect) allele
+// is always the target reference allele.
+func WithSumstatsTarget(target []fasta.Sequence) SumstatsOption {
+	return func(opts *sumstatsOptions) {
+		opts.target = target
+	}
+}
+
+// sumstatsColumns are the indices of the recognized summary statistics
+// columns, or -1 if the column is not present.
+type sumstatsColumns struct {
+	chromosome, position      int
+	effectAllele, otherAllele int
+	beta, z, oddsRatio        int
+	frequency                 int
+}
+
+// LiftSumstats lifts every row in the GWAS summary statistics table (tab,
+// comma or whitespace separated) read from r (optionally compressed) and
+// writes the harmonized table to w, and any rows that could not be lifted to
+// report (with an additional SumstatsReasonColumn column).
+//
+// The chromosome, position, allele and effect (beta, z-score, odds ratio and
+// effect allele frequency) columns are detected using their common header
+// names (eg. "CHR", "BP", "A1", "A2", "BETA"). Only the chromosome and position
+// columns are required. Rows lifted onto the negative strand have their alleles
+// reverse complemented, and are dropped if the alleles can not be (eg. indels).
+//
+// If target sequences are provided (see WithSumstatsTarget), and the target
+// reference base of a lifted single nucleotide variant matches the effect
+// allele rather than the other allele, the alleles are swapped and the
+// direction of the effect is flipped (the beta and z-score are negated, the
+// odds ratio inverted, and the effect allele frequency complemented).
+//
+// Lifted rows are written in the order they were read, and may need to be
+// sorted afterwards.
+func LiftSumstats(ctx context.Context, src ChainSource, from, to types.Reference, r io.Reader, w, report io.Writer, opts ...SumstatsOption) (*SumstatsStats, error) {
+	var options sumstatsOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	dr, err := compress.Decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress summary statistics: %w", err)
+	}
+	defer dr.Close()
+
+	var sequences map[types.Chromosome]*fasta.Sequence
+	if options.target != nil {
+		sequences = targetSequences(options.target)
+	}
+
+	bw := bufio.NewWriter(w)
+	breport := bufio.NewWriter(report)
+
+	stats := &SumstatsStats{
+		Dropped: make(map[string]int),
+	}
+
+	var columns *sumstatsColumns
+	var delimiter string
+
+	br := bufio.NewReader(dr)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read summary statistics: %w", err)
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if columns == nil {
+			// Metadata lines before the header are copied to both outputs.
+			header := line
+			if !strings.HasPrefix(line, "##") {
+				delimiter = detectSumstatsDelimiter(line)
+
+				fields, err := splitSumstatsRow(line, delimiter)
+				if err != nil {
+					return nil, fmt.Errorf("invalid summary statistics header: %w", err)
+				}
+
+				columns, err = detectSumstatsColumns(fields)
+				if err != nil {
+					return nil, err
+				}
+
+				header = line + delimiter + SumstatsReasonColumn
+			}
+
+			if _, err := bw.WriteString(line + "\n"); err != nil {
+				return nil, fmt.Errorf("could not write summary statistics header: %w", err)
+			}
+
+			if _, err := breport.WriteString(header + "\n"); err != nil {
+				return nil, fmt.Errorf("could not write summary statistics header: %w", err)
+			}
+
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var flipped bool
+		var reason string
+		fields, err := splitSumstatsRow(line, delimiter)
+		if err != nil {
+			reason = DropInvalidRow
+		} else {
+			flipped, reason, err = liftSumstatsRow(ctx, src, from, to, sequences, columns, fields)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if reason != "" {
+			stats.Dropped[reason]++
+
+			if delimiter == " " {
+				reason = strings.ReplaceAll(reason, " ", "_")
+			}
+
+			if _, err := breport.WriteString(line + delimiter + reason + "\n"); err != nil {
+				return nil, fmt.Errorf("could not write dropped row: %w", err)
+			}
+
+			continue
+		}
+
+		stats.Lifted++
+		if flipped {
+			stats.Flipped++
+		}
+
+		if _, err := bw.WriteString(joinSumstatsRow(fields, delimiter) + "\n"); err != nil {
+			return nil, fmt.Errorf("could not write lifted row: %w", err)
+		}
+	}
+
+	if columns == nil {
+		return nil, fmt.Errorf("could not read summary statistics: missing header")
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("could not write lifted rows: %w", err)
+	}
+
+	if err := breport.Flush(); err != nil {
+		return nil, fmt.Errorf("could not write dropped rows: %w", err)
+	}
+
+	return stats, nil
+}
+
+// liftSumstatsRow lifts a summary statistics row in place, returning whether
+// its effect was flipped, or the reason it was dropped.
+func liftSumstatsRow(ctx context.Context, src ChainSource, from, to types.Reference, sequences map[types.Chromosome]*fasta.Sequence, columns *sumstatsColumns, fields []string) (bool, string, error) {
+	for _, column := range []int{columns.chromosome, columns.position, columns.effectAllele, columns.otherAllele,
+		columns.beta, columns.z, columns.oddsRatio, columns.frequency} {
+		if column >= len(fields) {
+			return false, DropInvalidRow, nil
+		}
+	}
+
+	position, err := strconv.ParseInt(fields[columns.position], 10, 64)
+	if err != nil {
+		return false, DropInvalidRow, nil
+	}
+
+	result, err := Lift(ctx, src, from, to, names.Chromosome(fields[columns.chromosome]), position)
+	if err != nil {
+		if reason := UnmappedReason(err); reason != nil {
+			return false, reason.Error(), nil
+		}
+
+		return false, "", err
+	}
+
+	var effectAllele, otherAllele string
+	if columns.effectAllele != -1 {
+		effectAllele = strings.ToUpper(fields[columns.effectAllele])
+	}
+	if columns.otherAllele != -1 {
+		otherAllele = strings.ToUpper(fields[columns.otherAllele])
+	}
+
+	snv := isSumstatsBase(effectAllele) && isSumstatsBase(otherAllele)
+
+	if result.Strand == "-" {
+		for _, column := range []int{columns.effectAllele, columns.otherAllele} {
+			if column == -1 {
+				continue
+			}
+
+			// Indels are anchored on the preceding base, which is not known once
+			// reverse complemented.
+			allele := strings.ToUpper(fields[column])
+			if !isSumstatsBase(allele) {
+				return false, DropUnsupportedAlleles, nil
+			}
+
+			fields[column] = reverseComplement(allele)
+		}
+
+		effectAllele, otherAllele = reverseComplement(effectAllele), reverseComplement(otherAllele)
+	}
+
+	var flipped bool
+	if sequences != nil && snv {
+		sequence, ok := sequences[result.Chromosome]
+		if !ok {
+			return false, DropNoTargetSequence, nil
+		}
+
+		targetRef, err := sequence.Get(result.Position)
+		if err != nil {
+			return false, DropNoTargetSequence, nil
+		}
+
+		switch string(unicode.ToUpper(rune(targetRef))) {
+		case otherAllele:
+		case effectAllele:
+			fields[columns.effectAllele], fields[columns.otherAllele] = otherAllele, effectAllele
+			flipSumstatsEffects(columns, fields)
+			flipped = true
+		default:
+			return false, DropMismatchedRefAllele, nil
+		}
+	}
+
+	fields[columns.chromosome] = formatChromosome(fields[columns.chromosome], result.Chromosome)
+	fields[columns.position] = strconv.FormatInt(result.Position, 10)
+
+	return flipped, "", nil
+}
+
+// flipSumstatsEffects flips the direction of the effect of a row whose alleles
+// have been swapped.
+func flipSumstatsEffects(columns *sumstatsColumns, fields []string) {
+	for _, column := range []int{columns.beta, columns.z} {
+		if column != -1 {
+			fields[column] = negate(fields[column])
+		}
+	}
+
+	if columns.oddsRatio != -1 {
+		fields[columns.oddsRatio] = invert(fields[columns.oddsRatio])
+	}
+
+	if columns.frequency != -1 {
+		fields[columns.frequency] = flipFrequency(fields[columns.frequency])
+	}
+}
+
+// detectSumstatsDelimiter returns the column delimiter used by the header.
+func detectSumstatsDelimiter(header string) string {
+	switch {
+	case strings.Contains(header, "\t"):
+		return "\t"
+	case strings.Contains(header, ","):
+		return ","
+	default:
+		return " "
+	}
+}
+
+// splitSumstatsRow splits a row into its fields. Comma separated rows may have
+// quoted fields (eg. a trait name containing a comma).
+func splitSumstatsRow(line, delimiter string) ([]string, error) {
+	switch delimiter {
+	case " ":
+		return strings.Fields(line), nil
+	case ",":
+		csvReader := csv.NewReader(strings.NewReader(line))
+		csvReader.FieldsPerRecord = -1
+
+		return csvReader.Read()
+	default:
+		return strings.Split(line, delimiter), nil
+	}
+}
+
+// joinSumstatsRow joins the fields of a row, quoting comma separated fields
+// where required.
+func joinSumstatsRow(fields []string, delimiter string) string {
+	if delimiter != "," {
+		return strings.Join(fields, delimiter)
+	}
+
+	var sb strings.Builder
+	csvWriter := csv.NewWriter(&sb)
+	// Writing to a strings.Builder can not fail.
+	_ = csvWriter.Write(fields)
+	csvWriter.Flush()
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// detectSumstatsColumns finds the recognized columns of the header.
+func detectSumstatsColumns(header []string) (*sumstatsColumns, error) {
+	normalized := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+
+			return -1
+		}, name)
+
+		if _, ok := normalized[name]; !ok {
+			normalized[name] = i
+		}
+	}
+
+	find := func(aliases []string) int {
+		for _, alias := range aliases {
+			if i, ok := normalized[alias]; ok {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	columns := &sumstatsColumns{
+		chromosome:   find(sumstatsChromosomeColumns),
+		position:     find(sumstatsPositionColumns),
+		effectAllele: find(sumstatsEffectAlleleColumns),
+		otherAllele:  find(sumstatsOtherAlleleColumns),
+		beta:         find(sumstatsBetaColumns),
+		z:            find(sumstatsZColumns),
+		oddsRatio:    find(sumstatsOddsRatioColumns),
+		frequency:    find(sumstatsFrequencyColumns),
+	}
+
+	if columns.chromosome == -1 || columns.position == -1 {
+		return nil, fmt.Errorf("could not detect chromosome and position columns in header: %q", strings.Join(header, " "))
+	}
+
+	return columns, nil
+}
+
+func isSumstatsBase(allele string) bool {
+	return len(allele) == 1 && strings.Contains("ACGT", allele)
+}
+
+// negate negates a number, preserving its formatting.
+func negate(value string) string {
+	if number, err := strconv.ParseFloat(value, 64); err != nil || number == 0 {
+		return value
+	}
+
+	if strings.HasPrefix(value, "-") {
+		return value[1:]
+	}
+
+	return "-" + strings.TrimPrefix(value, "+")
+}
+
+// invert returns the reciprocal of a number (eg. an odds ratio), preserving
+// the precision of the original value.
+func invert(value string) string {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number == 0 {
+		return value
+	}
+
+	precision := -1
+	if _, decimals, ok := strings.Cut(value, "."); ok && !strings.ContainsAny(decimals, "eE") {
+		precision = len(decimals)
+	}
+
+	return strconv.FormatFloat(1/number, 'f', precision, 64)
+}
@@ -0,0 +1,390 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/fasta"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+// DefaultSVSpanTolerance is the default maximum fractional change in the span
+// of a structural variant (or between breakends on the same chromosome) for it
+// to be lifted.
+const DefaultSVSpanTolerance = 0.1
+
+// Reasons a structural variant could not be lifted, recorded in the rejects
+// file using the RejectReasonInfoKey INFO field.
+const (
+	// RejectBreakpointsOnDifferentContigs indicates the breakpoints of the
+	// structural variant were lifted to different target chromosomes.
+	RejectBreakpointsOnDifferentContigs = "BreakpointsOnDifferentContigs"
+	// RejectSpanChanged indicates the span of the structural variant changed by
+	// more than the tolerance, or its breakpoints were lifted in different
+	// orientations.
+	RejectSpanChanged = "SpanChanged"
+)
+
+const (
+	vcfEndInfoKey      = "END"
+	vcfSVLenInfoKey    = "SVLEN"
+	vcfSVTypeInfoKey   = "SVTYPE"
+	vcfSVTypeInsertion = "INS"
+)
+
+type vcfOptions struct {
+	svSpanTolerance float64
+}
+
+// VCFOption configures the behavior of LiftVCF.
+type VCFOption func(*vcfOptions)
+
+// WithSVSpanTolerance sets the maximum fractional change in the span of a
+// structural variant (eg. 0.1 for 10%) for it to be lifted.
+func WithSVSpanTolerance(tolerance float64) VCFOption {
+	return func(opts *vcfOptions) {
+		opts.svSpanTolerance = tolerance
+	}
+}
+
+// isStructuralVariant returns true if the alleles of the record are symbolic
+// (eg. "<DEL>") or breakends (eg. "G]17:198982]").
+func isStructuralVariant(alts []string) bool {
+	for _, alt := range alts {
+		if isSymbolicAllele(alt) || isBreakend(alt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSymbolicAllele(allele string) bool {
+	return strings.HasPrefix(allele, "<") && strings.HasSuffix(allele, ">")
+}
+
+func isBreakend(allele string) bool {
+	return strings.ContainsAny(allele, "[]") ||
+		(len(allele) > 1 && (strings.HasPrefix(allele, ".") || strings.HasSuffix(allele, ".")))
+}
+
+// liftStructuralVariant lifts a structural variant record, returning either
+// the lifted record or the reason it was rejected. Both breakpoints of
+// symbolic alleles (POS and END) are lifted, as are the mates of breakends.
+func liftStructuralVariant(ctx context.Context, src ChainSource, from, to types.Reference, sequences map[types.Chromosome]*fasta.Sequence, fields []string, position int64, alts []string, options vcfOptions) (*liftedVCFRecord, string, error) {
+	var symbolic, breakends bool
+	for _, alt := range alts {
+		switch {
+		case isSymbolicAllele(alt):
+			symbolic = true
+		case isBreakend(alt):
+			breakends = true
+		case alt != "*":
+			return nil, RejectUnsupportedAllele, nil
+		}
+	}
+
+	if symbolic && breakends {
+		return nil, RejectUnsupportedAllele, nil
+	}
+
+	chromosome := names.Chromosome(fields[0])
+
+	start, reason, err := liftBreakpoint(ctx, src, from, to, chromosome, position)
+	if reason != "" || err != nil {
+		return nil, reason, err
+	}
+
+	sequence, ok := sequences[start.Chromosome]
+	if !ok {
+		return nil, RejectNoTargetSequence, nil
+	}
+
+	lifted := make([]string, len(fields))
+	copy(lifted, fields)
+
+	info := lifted[7]
+	newPosition := start.Position
+
+	if symbolic {
+		svType, _ := getInfo(info, vcfSVTypeInfoKey)
+
+		end := position
+		if value, ok := getInfo(info, vcfEndInfoKey); ok {
+			if end, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, RejectUnsupportedAllele, nil
+			}
+		} else if value, ok := getInfo(info, vcfSVLenInfoKey); ok && svType != vcfSVTypeInsertion {
+			svLen, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, RejectUnsupportedAllele, nil
+			}
+
+			end = position + abs(svLen)
+		}
+
+		newEnd := newPosition
+		if end != position {
+			endResult, reason, err := liftBreakpoint(ctx, src, from, to, chromosome, end)
+			if reason != "" || err != nil {
+				return nil, reason, err
+			}
+
+			if endResult.Chromosome != start.Chromosome {
+				return nil, RejectBreakpointsOnDifferentContigs, nil
+			}
+
+			if endResult.Strand != start.Strand {
+				return nil, RejectSpanChanged, nil
+			}
+
+			newEnd = endResult.Position
+		}
+
+		if start.Strand == "-" {
+			// The variant now starts at the lifted END, and is padded by the base
+			// before it.
+			newPosition, newEnd = newEnd-1, newPosition-1
+		}
+
+		if !withinTolerance(end-position, newEnd-newPosition, options.svSpanTolerance) {
+			return nil, RejectSpanChanged, nil
+		}
+
+		if _, ok := getInfo(info, vcfEndInfoKey); ok {
+			info = setInfo(info, vcfEndInfoKey, strconv.FormatInt(newEnd, 10))
+		}
+
+		if value, ok := getInfo(info, vcfSVLenInfoKey); ok {
+			if svLen, err := strconv.ParseInt(value, 10, 64); err == nil && svType != vcfSVTypeInsertion && end != position {
+				newSVLen := newEnd - newPosition
+				if svLen < 0 {
+					newSVLen = -newSVLen
+				}
+
+				info = setInfo(info, vcfSVLenInfoKey, strconv.FormatInt(newSVLen, 10))
+			}
+		}
+	} else {
+		for i, alt := range alts {
+			if alt == "*" {
+				continue
+			}
+
+			liftedAlt, reason, err := liftBreakend(ctx, src, from, to, sequence, chromosome, position, start, alt, options)
+			if reason != "" || err != nil {
+				return nil, reason, err
+			}
+
+			alts[i] = liftedAlt
+		}
+
+		lifted[4] = strings.Join(alts, ",")
+	}
+
+	// The reference allele is the padding base of the variant.
+	targetRef, err := sequence.Get(newPosition)
+	if err != nil {
+		return nil, RejectNoTarget, nil
+	}
+
+	lifted[0] = formatChromosome(fields[0], start.Chromosome)
+	lifted[1] = strconv.FormatInt(newPosition, 10)
+	lifted[3] = string(targetRef)
+
+	info = appendInfo(info, OriginalContigInfoKey+"="+fields[0])
+	info = appendInfo(info, OriginalStartInfoKey+"="+fields[1])
+	if start.Strand == "-" {
+		info = appendInfo(info, ReverseComplementedInfoKey)
+	}
+	lifted[7] = info
+
+	return &liftedVCFRecord{
+		fields: lifted,
+	}, "", nil
+}
+
+// liftBreakend lifts the mate of a breakend allele, and rewrites the allele to
+// match the lifted breakend and mate (eg. "G]17:198982]").
+func liftBreakend(ctx context.Context, src ChainSource, from, to types.Reference, sequence *fasta.Sequence, chromosome types.Chromosome, position int64, lifted *LiftResult, alt string, options vcfOptions) (string, string, error) {
+	// Whether the sequence before the breakend is kept (eg. "G[p[" or "G."),
+	// rather than the sequence after it (eg. "]p]G" or ".G").
+	ownLeft := !strings.HasPrefix(alt, "[") && !strings.HasPrefix(alt, "]") && !strings.HasPrefix(alt, ".")
+
+	var bases, mate string
+	if i := strings.IndexAny(alt, "[]"); i >= 0 {
+		j := strings.LastIndexAny(alt, "[]")
+		if j <= i {
+			return "", RejectUnsupportedAllele, nil
+		}
+
+		mate = alt[i : j+1]
+		bases = alt[:i] + alt[j+1:]
+	} else {
+		bases = strings.Trim(alt, ".")
+	}
+
+	if !isSimpleAllele(bases) {
+		return "", RejectUnsupportedAllele, nil
+	}
+
+	// Any inserted bases, besides the reference base.
+	inserted := bases[:len(bases)-1]
+	if ownLeft {
+		inserted = bases[1:]
+	}
+
+	if lifted.Strand == "-" {
+		ownLeft = !ownLeft
+		inserted = reverseComplement(inserted)
+	}
+
+	targetBase, err := sequence.Get(lifted.Position)
+	if err != nil {
+		return "", RejectNoTarget, nil
+	}
+
+	bases = inserted + string(targetBase)
+	if ownLeft {
+		bases = string(targetBase) + inserted
+	}
+
+	if mate == "" {
+		if ownLeft {
+			return bases + ".", "", nil
+		}
+
+		return "." + bases, "", nil
+	}
+
+	// Whether the mate's sequence after its breakend is joined (eg. "G[p[").
+	mateRight := mate[0] == '['
+
+	mateChromosome, matePosition, ok := parseBreakendMate(mate[1 : len(mate)-1])
+	if !ok {
+		return "", RejectUnsupportedAllele, nil
+	}
+
+	mateLifted, reason, err := liftBreakpoint(ctx, src, from, to, names.Chromosome(mateChromosome), matePosition)
+	if reason != "" || err != nil {
+		return "", reason, err
+	}
+
+	if names.Chromosome(mateChromosome) == chromosome {
+		if mateLifted.Chromosome != lifted.Chromosome {
+			return "", RejectBreakpointsOnDifferentContigs, nil
+		}
+
+		if !withinTolerance(abs(matePosition-position), abs(mateLifted.Position-lifted.Position), options.svSpanTolerance) {
+			return "", RejectSpanChanged, nil
+		}
+	}
+
+	if mateLifted.Strand == "-" {
+		mateRight = !mateRight
+	}
+
+	bracket := "]"
+	if mateRight {
+		bracket = "["
+	}
+
+	mate = bracket + formatChromosome(mateChromosome, mateLifted.Chromosome) + ":" + strconv.FormatInt(mateLifted.Position, 10) + bracket
+
+	if ownLeft {
+		return bases + mate, "", nil
+	}
+
+	return mate + bases, "", nil
+}
+
+// liftBreakpoint lifts a single breakpoint, returning the reason it could not
+// be lifted if it is unmapped.
+func liftBreakpoint(ctx context.Context, src ChainSource, from, to types.Reference, chromosome types.Chromosome, position int64) (*LiftResult, string, error) {
+	result, err := Lift(ctx, src, from, to, chromosome, position)
+	if err != nil {
+		if UnmappedReason(err) != nil {
+			return nil, RejectNoTarget, nil
+		}
+
+		return nil, "", fmt.Errorf("could not lift breakpoint %s:%d: %w", chromosome, position, err)
+	}
+
+	return result, "", nil
+}
+
+// parseBreakendMate parses the "chr:pos" mate of a breakend.
+func parseBreakendMate(mate string) (string, int64, bool) {
+	i := strings.LastIndex(mate, ":")
+	if i <= 0 || strings.HasPrefix(mate, "<") {
+		// Breakends joined to assembled contigs (eg. "<ctg1>:1") are not supported.
+		return "", 0, false
+	}
+
+	position, err := strconv.ParseInt(mate[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return mate[:i], position, true
+}
+
+// withinTolerance returns true if a span changed by no more than the given
+// fraction (and did not change sign).
+func withinTolerance(span, newSpan int64, tolerance float64) bool {
+	if newSpan < 0 {
+		return false
+	}
+
+	if span == 0 {
+		return newSpan == 0
+	}
+
+	return float64(abs(newSpan-span))/float64(span) <= tolerance
+}
+
+func abs(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
+
+// getInfo returns the value of an INFO field.
+func getInfo(info, key string) (string, bool) {
+	for _, entry := range strings.Split(info, vcfInfoSeparator) {
+		if k, value, _ := strings.Cut(entry, "="); k == key {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// setInfo replaces the value of an INFO field.
+func setInfo(info, key, value string) string {
+	entries := strings.Split(info, vcfInfoSeparator)
+	for i, entry := range entries {
+		if k, _, _ := strings.Cut(entry, "="); k == key {
+			entries[i] = key + "=" + value
+		}
+	}
+
+	return strings.Join(entries, vcfInfoSeparator)
+}
@@ -0,0 +1,573 @@
+/* SPDX-License-Identifier: MPL-2.0
+ *
+ * Zymatik Nucleo - A Bioinformatics library for Go.
+ * Copyright (C) 2024 Damian Peckett <damian@pecke.tt>
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the Mozilla Public License v2.0.
+ *
+ * You should have received a copy of the Mozilla Public License v2.0
+ * along with this program. If not, see <https://mozilla.org/MPL/2.0/>.
+ */
+
+package liftover
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zymatik-com/genobase/types"
+	"github.com/zymatik-com/nucleo/compress"
+	"github.com/zymatik-com/nucleo/fasta"
+	"github.com/zymatik-com/nucleo/names"
+)
+
+// Reasons a VCF record could not be lifted, recorded in the rejects file
+// using the RejectReasonInfoKey INFO field.
+const (
+	// RejectNoTarget indicates the record could not be lifted to the target genome.
+	RejectNoTarget = "NoTarget"
+	// RejectMismatchedRefAllele indicates the target reference allele matches
+	// neither the REF nor the ALT allele of the record.
+	RejectMismatchedRefAllele = "MismatchedRefAllele"
+	// RejectIndelStraddlesMultipleIntervals indicates the alleles of the record
+	// span a gap in the alignment.
+	RejectIndelStraddlesMultipleIntervals = "IndelStraddlesMultipleIntervals"
+	// RejectUnsupportedAllele indicates the record contains alleles that cannot
+	// be lifted (eg. symbolic or breakend alleles).
+	RejectUnsupportedAllele = "UnsupportedAllele"
+	// RejectNoTargetSequence indicates the target FASTA does not contain the
+	// chromosome the record was lifted to.
+	RejectNoTargetSequence = "NoTargetSequence"
+)
+
+// INFO fields added to lifted and rejected VCF records.
+const (
+	OriginalContigInfoKey      = "OriginalContig"
+	OriginalStartInfoKey       = "OriginalStart"
+	ReverseComplementedInfoKey = "ReverseComplementedAlleles"
+	SwappedAllelesInfoKey      = "SwappedAlleles"
+	RejectReasonInfoKey        = "LiftoverRejectReason"
+)
+
+const (
+	alleleFrequencyInfoKey         = "AF"
+	genotypeFormatKey              = "GT"
+	vcfMissingValue                = "."
+	vcfInfoSeparator               = ";"
+	vcfUCSCChromosomePrefix        = "chr"
+	vcfUCSCMitochondrialChromosome = "chrM"
+)
+
+// VCFStats summarizes the result of lifting a VCF file.
+type VCFStats struct {
+	Lifted   int            // Number of records lifted to the target genome.
+	Swapped  int            // Number of lifted records whose REF and ALT alleles were swapped.
+	Rejected map[string]int // Number of records rejected, by reason.
+}
+
+// LiftVCF lifts every record in the VCF read from r (optionally compressed)
+// and writes the lifted records to w, and any records that could not be lifted
+// to rejects (annotated with the reason they were rejected).
+//
+// The REF allele of each lifted record is checked against the target genome
+// sequences. If the target reference base matches the ALT allele of a biallelic
+// record, the alleles are swapped and the GT and AF fields updated to match.
+// Records lifted onto the negative strand have their alleles reverse
+// complemented. Target sequences are matched to chromosomes using the first word
+// of their FASTA description (eg. "chr1" or "1"), and the ##contig lines of the
+// source genome are replaced by those of the target sequences (named in the
+// style of the source contigs, if there are any).
+//
+// Structural variants with symbolic alleles (eg. "<DEL>") have both of their
+// breakpoints (POS and END) lifted, and END and SVLEN updated to match, while
+// breakends (eg. "G]17:198982]") have their mates lifted. Structural variants
+// whose breakpoints are lifted to different chromosomes, or whose span changes
+// by more than the tolerance (see WithSVSpanTolerance), are rejected.
+//
+// Lifted records are written in the order they were read, and may need to be
+// sorted afterwards.
+func LiftVCF(ctx context.Context, src ChainSource, from, to types.Reference, target []fasta.Sequence, r io.Reader, w, rejects io.Writer, opts ...VCFOption) (*VCFStats, error) {
+	options := vcfOptions{
+		svSpanTolerance: DefaultSVSpanTolerance,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	dr, err := compress.Decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress vcf: %w", err)
+	}
+	defer dr.Close()
+
+	sequences := targetSequences(target)
+	header := &vcfHeader{to: to, target: target}
+
+	bw := bufio.NewWriter(w)
+	brejects := bufio.NewWriter(rejects)
+
+	stats := &VCFStats{
+		Rejected: make(map[string]int),
+	}
+
+	br := bufio.NewReader(dr)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read vcf: %w", err)
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if err := header.writeLine(bw, brejects, line); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		record, reason, err := liftVCFRecord(ctx, src, from, to, sequences, line, options)
+		if err != nil {
+			return nil, err
+		}
+
+		if reason != "" {
+			stats.Rejected[reason]++
+
+			fields := strings.Split(line, "\t")
+			fields[7] = appendInfo(fields[7], RejectReasonInfoKey+"="+reason)
+
+			if _, err := brejects.WriteString(strings.Join(fields, "\t") + "\n"); err != nil {
+				return nil, fmt.Errorf("could not write rejected record: %w", err)
+			}
+
+			continue
+		}
+
+		stats.Lifted++
+		if record.swapped {
+			stats.Swapped++
+		}
+
+		if _, err := bw.WriteString(strings.Join(record.fields, "\t") + "\n"); err != nil {
+			return nil, fmt.Errorf("could not write lifted record: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("could not write lifted records: %w", err)
+	}
+
+	if err := brejects.Flush(); err != nil {
+		return nil, fmt.Errorf("could not write rejected records: %w", err)
+	}
+
+	return stats, nil
+}
+
+// targetSequences indexes the target sequences by the chromosome named by the
+// first word of their FASTA description.
+func targetSequences(target []fasta.Sequence) map[types.Chromosome]*fasta.Sequence {
+	sequences := make(map[types.Chromosome]*fasta.Sequence, len(target))
+	for i := range target {
+		fields := strings.Fields(target[i].Description)
+		if len(fields) == 0 {
+			continue
+		}
+
+		sequences[names.Chromosome(fields[0])] = &target[i]
+	}
+
+	return sequences
+}
+
+// vcfHeader rewrites the header of a VCF for the target genome.
+type vcfHeader struct {
+	to     types.Reference
+	target []fasta.Sequence
+	// sourceContig is the ID of the first contig of the source genome, which
+	// names chromosomes in the style the records use (eg. "chr1" or "1").
+	sourceContig string
+}
+
+func (h *vcfHeader) writeLine(w, rejects *bufio.Writer, line string) error {
+	var lifted []string
+	rejected := []string{line}
+
+	switch {
+	case strings.HasPrefix(line, "##contig="):
+		// Contigs describe the source genome, and are replaced by the target
+		// contigs.
+		if h.sourceContig == "" {
+			h.sourceContig = vcfContigID(line)
+		}
+	case strings.HasPrefix(line, "##reference="):
+		lifted = append(lifted, "##reference="+string(h.to))
+	case strings.HasPrefix(line, "#CHROM"):
+		lifted = append(lifted, h.targetContigs()...)
+		lifted = append(lifted,
+			fmt.Sprintf(`##INFO=<ID=%s,Number=1,Type=String,Description="The name of the source contig/chromosome prior to liftover.">`, OriginalContigInfoKey),
+			fmt.Sprintf(`##INFO=<ID=%s,Number=1,Type=Integer,Description="The position of the variant on the source contig prior to liftover.">`, OriginalStartInfoKey),
+			fmt.Sprintf(`##INFO=<ID=%s,Number=0,Type=Flag,Description="The REF and the ALT alleles have been reverse complemented in liftover since the mapping from the previous reference to the current one was on the negative strand.">`, ReverseComplementedInfoKey),
+			fmt.Sprintf(`##INFO=<ID=%s,Number=0,Type=Flag,Description="The REF and the ALT alleles have been swapped in liftover due to changes in the reference. It is possible that not all INFO annotations reflect this swap.">`, SwappedAllelesInfoKey),
+			line)
+
+		rejected = []string{
+			fmt.Sprintf(`##INFO=<ID=%s,Number=1,Type=String,Description="The reason the variant could not be lifted over.">`, RejectReasonInfoKey),
+			line,
+		}
+	default:
+		lifted = append(lifted, line)
+	}
+
+	for _, l := range lifted {
+		if _, err := w.WriteString(l + "\n"); err != nil {
+			return fmt.Errorf("could not write vcf header: %w", err)
+		}
+	}
+
+	for _, l := range rejected {
+		if _, err := rejects.WriteString(l + "\n"); err != nil {
+			return fmt.Errorf("could not write vcf header: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// targetContigs returns the ##contig lines of the target sequences.
+func (h *vcfHeader) targetContigs() []string {
+	var contigs []string
+	for _, sequence := range h.target {
+		fields := strings.Fields(sequence.Description)
+		if len(fields) == 0 {
+			continue
+		}
+
+		id := fields[0]
+		if h.sourceContig != "" {
+			id = formatChromosome(h.sourceContig, names.Chromosome(id))
+		}
+
+		contigs = append(contigs, fmt.Sprintf("##contig=<ID=%s,length=%d,assembly=%s>", id, len(sequence.Values), h.to))
+	}
+
+	return contigs
+}
+
+// vcfContigID returns the ID of a ##contig line.
+func vcfContigID(line string) string {
+	attributes := strings.TrimSuffix(strings.TrimPrefix(line, "##contig=<"), ">")
+	for _, attribute := range strings.Split(attributes, ",") {
+		if id, ok := strings.CutPrefix(attribute, "ID="); ok {
+			return id
+		}
+	}
+
+	return ""
+}
+
+type liftedVCFRecord struct {
+	fields  []string
+	swapped bool
+}
+
+// liftVCFRecord lifts a single VCF record, returning either the lifted record
+// or the reason it was rejected.
+func liftVCFRecord(ctx context.Context, src ChainSource, from, to types.Reference, sequences map[types.Chromosome]*fasta.Sequence, line string, options vcfOptions) (*liftedVCFRecord, string, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 8 {
+		return nil, "", fmt.Errorf("invalid vcf record: %q", line)
+	}
+
+	position, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid vcf position %q: %w", fields[1], err)
+	}
+
+	ref := strings.ToUpper(fields[3])
+	var alts []string
+	if fields[4] != vcfMissingValue {
+		alts = strings.Split(strings.ToUpper(fields[4]), ",")
+	}
+
+	if isStructuralVariant(alts) {
+		// Breakend mates are case sensitive chromosome names.
+		return liftStructuralVariant(ctx, src, from, to, sequences, fields, position, strings.Split(fields[4], ","), options)
+	}
+
+	for _, allele := range append([]string{ref}, alts...) {
+		if !isSimpleAllele(allele) {
+			return nil, RejectUnsupportedAllele, nil
+		}
+	}
+
+	chromosome := names.Chromosome(fields[0])
+	end := position + int64(len(ref)) - 1
+
+	result, err := LiftInterval(ctx, src, from, to, chromosome, position, end, WithMinMatch(1))
+	if err != nil {
+		if UnmappedReason(err) != nil {
+			return nil, RejectNoTarget, nil
+		}
+
+		return nil, "", fmt.Errorf("could not lift %s:%d-%d: %w", chromosome, position, end, err)
+	}
+
+	segment := result.Segments[0]
+	if segment.End-segment.Start != end-position {
+		return nil, RejectIndelStraddlesMultipleIntervals, nil
+	}
+
+	sequence, ok := sequences[segment.Chromosome]
+	if !ok {
+		return nil, RejectNoTargetSequence, nil
+	}
+
+	newPosition := segment.Start
+	reverseComplemented := segment.Strand == "-"
+	if reverseComplemented {
+		ref = reverseComplement(ref)
+		for i := range alts {
+			if alts[i] != "*" {
+				alts[i] = reverseComplement(alts[i])
+			}
+		}
+
+		// Indels are anchored on the base preceding the event, which is now at the
+		// end of the alleles, so re-anchor them on the preceding target base.
+		if hasPaddingBase(ref, alts) {
+			paddingBase, err := sequence.Get(newPosition - 1)
+			if err != nil {
+				return nil, RejectNoTarget, nil
+			}
+
+			ref = string(paddingBase) + ref[:len(ref)-1]
+			for i := range alts {
+				if alts[i] != "*" {
+					alts[i] = string(paddingBase) + alts[i][:len(alts[i])-1]
+				}
+			}
+
+			newPosition--
+		}
+	}
+
+	targetRef, err := sequence.GetRange(newPosition, newPosition+int64(len(ref))-1)
+	if err != nil {
+		return nil, RejectNoTarget, nil
+	}
+
+	var swapped bool
+	if string(targetRef) != ref {
+		if len(alts) != 1 || alts[0] != string(targetRef) {
+			return nil, RejectMismatchedRefAllele, nil
+		}
+
+		ref, alts[0] = alts[0], ref
+		swapped = true
+	}
+
+	lifted := make([]string, len(fields))
+	copy(lifted, fields)
+
+	lifted[0] = formatChromosome(fields[0], segment.Chromosome)
+	lifted[1] = strconv.FormatInt(newPosition, 10)
+	lifted[3] = ref
+	if len(alts) > 0 {
+		lifted[4] = strings.Join(alts, ",")
+	}
+
+	info := lifted[7]
+	if swapped {
+		info = flipAlleleFrequencies(info)
+	}
+	info = appendInfo(info, OriginalContigInfoKey+"="+fields[0])
+	info = appendInfo(info, OriginalStartInfoKey+"="+fields[1])
+	if reverseComplemented {
+		info = appendInfo(info, ReverseComplementedInfoKey)
+	}
+	if swapped {
+		info = appendInfo(info, SwappedAllelesInfoKey)
+	}
+	lifted[7] = info
+
+	if swapped && len(lifted) > 9 {
+		flipGenotypes(lifted[8], lifted[9:])
+	}
+
+	return &liftedVCFRecord{
+		fields:  lifted,
+		swapped: swapped,
+	}, "", nil
+}
+
+// isSimpleAllele returns true if the allele is made up only of bases (or is
+// the spanning deletion allele).
+func isSimpleAllele(allele string) bool {
+	if allele == "*" {
+		return true
+	}
+
+	if allele == "" {
+		return false
+	}
+
+	for _, b := range []byte(allele) {
+		switch b {
+		case 'A', 'C', 'G', 'T', 'N':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasPaddingBase returns true if the record is an indel whose alleles all share
+// the same padding base.
+func hasPaddingBase(ref string, alts []string) bool {
+	var indel bool
+	for _, alt := range alts {
+		if alt == "*" {
+			continue
+		}
+
+		if len(alt) != len(ref) {
+			indel = true
+		}
+
+		if alt[len(alt)-1] != ref[len(ref)-1] {
+			return false
+		}
+	}
+
+	return indel
+}
+
+func reverseComplement(bases string) string {
+	complemented := make([]byte, len(bases))
+	for i := 0; i < len(bases); i++ {
+		var b byte
+		switch bases[len(bases)-1-i] {
+		case 'A':
+			b = 'T'
+		case 'C':
+			b = 'G'
+		case 'G':
+			b = 'C'
+		case 'T':
+			b = 'A'
+		default:
+			b = 'N'
+		}
+		complemented[i] = b
+	}
+
+	return string(complemented)
+}
+
+// formatChromosome formats the lifted chromosome using the same naming
+// convention as the original record (eg. "chr1" vs "1").
+func formatChromosome(original string, chromosome types.Chromosome) string {
+	if !strings.HasPrefix(original, vcfUCSCChromosomePrefix) {
+		return string(chromosome)
+	}
+
+	if chromosome == types.ChrMT {
+		return vcfUCSCMitochondrialChromosome
+	}
+
+	return vcfUCSCChromosomePrefix + string(chromosome)
+}
+
+func appendInfo(info, entry string) string {
+	if info == "" || info == vcfMissingValue {
+		return entry
+	}
+
+	return info + vcfInfoSeparator + entry
+}
+
+// flipAlleleFrequencies replaces the AF INFO field with the frequency of the
+// (now swapped) reference allele.
+func flipAlleleFrequencies(info string) string {
+	entries := strings.Split(info, vcfInfoSeparator)
+	for i, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key != alleleFrequencyInfoKey {
+			continue
+		}
+
+		entries[i] = key + "=" + flipFrequency(value)
+	}
+
+	return strings.Join(entries, vcfInfoSeparator)
+}
+
+func flipFrequency(value string) string {
+	frequency, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+
+	// Preserve the precision of the original value.
+	precision := -1
+	if _, decimals, ok := strings.Cut(value, "."); ok && !strings.ContainsAny(decimals, "eE") {
+		precision = len(decimals)
+	}
+
+	return strconv.FormatFloat(1-frequency, 'f', precision, 64)
+}
+
+// flipGenotypes swaps the reference and alternate allele indices of the GT
+// field of every sample.
+func flipGenotypes(format string, samples []string) {
+	gtIndex := -1
+	for i, key := range strings.Split(format, ":") {
+		if key == genotypeFormatKey {
+			gtIndex = i
+			break
+		}
+	}
+	if gtIndex == -1 {
+		return
+	}
+
+	for i, sample := range samples {
+		values := strings.Split(sample, ":")
+		if gtIndex >= len(values) {
+			continue
+		}
+
+		gt := []byte(values[gtIndex])
+		for j, b := range gt {
+			// Only biallelic records are swapped, so all indices are single digits.
+			switch b {
+			case '0':
+				gt[j] = '1'
+			case '1':
+				gt[j] = '0'
+			}
+		}
+		values[gtIndex] = string(gt)
+
+		samples[i] = strings.Join(values, ":")
+	}
+}